@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/0xcafed00d/tracetcp-go/tracetcp"
+)
+
+// target is a host to trace to and, once looked up, which of its addresses.
+type target struct {
+	host  string
+	port  int
+	addr  *net.IPAddr
+	index int
+	count int
+
+	// an address that can not be traced yet, which is skipped
+	unsupported bool
+}
+
+// implementation of fmt.Stinger interface
+func (t target) String() string {
+	if t.count > 1 {
+		return fmt.Sprintf("%v:%v %v (%v address %d of %d)", t.host, t.port, t.addr.IP, tracetcp.AddrFamily(t.addr.IP), t.index, t.count)
+	}
+	return fmt.Sprintf("%v:%v", t.host, t.port)
+}
+
+// validAddressChoice checks the value of -a.
+func validAddressChoice(choice string) error {
+	if n, err := strconv.Atoi(choice); choice == "" || choice == "all" || err == nil && n > 0 {
+		return nil
+	}
+	return fmt.Errorf("Invalid address choice: %v", choice)
+}
+
+// chooseAddresses returns a target for each of the addresses of t chosen with
+// -a: the first IPv4 address by default, the Nth, or all of them in the order
+// the resolver returned them. IPv6 addresses can not be traced yet, so they
+// can not be chosen, and are only returned by all, marked unsupported.
+func chooseAddresses(t target, addrs []*net.IPAddr, choice string) ([]target, error) {
+	var targets []target
+	traceable := 0
+	for i, addr := range addrs {
+		ipv4 := addr.IP.To4() != nil
+		switch {
+		case choice == "all":
+		case choice == "" && ipv4 && traceable == 0:
+		case choice == strconv.Itoa(i+1):
+			if !ipv4 {
+				return nil, fmt.Errorf("Address %d of %v is %v, which can not be traced yet", i+1, t.host, addr.IP)
+			}
+		default:
+			continue
+		}
+		if ipv4 {
+			traceable++
+		}
+		targets = append(targets, target{t.host, t.port, addr, i + 1, len(addrs), !ipv4})
+	}
+	if len(targets) == 0 && choice != "" {
+		return nil, fmt.Errorf("%v has only %d addresses", t.host, len(addrs))
+	}
+	if traceable == 0 {
+		return nil, fmt.Errorf("%v has no IPv4 addresses, which are all that can be traced yet", t.host)
+	}
+	return targets, nil
+}
+
+// lookupTarget sets the address traced to the one of opts.Target chosen with
+// -a, or the first IPv4 address if all were chosen.
+func lookupTarget(opts *tracetcp.Options) error {
+	addrs, err := tracetcp.LookupAddresses(opts.Target)
+	if err != nil {
+		return err
+	}
+	chosen, err := chooseAddresses(target{host: opts.Target, port: opts.Port}, addrs, config.Addresses)
+	if err != nil {
+		return err
+	}
+	for _, t := range chosen {
+		if !t.unsupported {
+			opts.Addr, opts.AddrIndex, opts.AddrCount = t.addr, t.index, t.count
+			break
+		}
+	}
+	return nil
+}
+
+// resolveTargets looks up each target's addresses. a target whose lookup
+// fails is kept unresolved, so that it is reported as failed with the rest.
+func resolveTargets(targets []target) []target {
+	var resolved []target
+	for _, t := range targets {
+		addrs, err := tracetcp.LookupAddresses(t.host)
+		if err == nil {
+			var chosen []target
+			if chosen, err = chooseAddresses(t, addrs, config.Addresses); err == nil {
+				resolved = append(resolved, chosen...)
+				continue
+			}
+		}
+		resolved = append(resolved, t)
+	}
+	return resolved
+}
+
+// readTargets reads one hostname[:port] per line. blank lines and lines
+// starting with # are skipped.
+func readTargets(r io.Reader, defaultPort int) ([]target, error) {
+	var targets []target
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		host, port, err := tracetcp.SplitHostAndPort(text, defaultPort)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", line, err)
+		}
+		targets = append(targets, target{host: host, port: port})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("No targets supplied")
+	}
+	return targets, nil
+}
+
+// syncWriter serialises the writes of traces running at once, so that each
+// write reaches the output whole.
+type syncWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.Write(p)
+}
+
+// outputWriterName is the name of the output format chosen with -o, without
+// its argument.
+func outputWriterName() string {
+	return strings.SplitN(strings.SplitN(config.OutputWriter, "=", 2)[0], ":", 2)[0]
+}
+
+// outputFileName is the name of the file a target's trace is written to with
+// -d, named for the target and the output format.
+func outputFileName(t target) string {
+	ext := "txt"
+	switch name := outputWriterName(); name {
+	case "json", "ndjson", "csv", "tsv", "dot", "graphml", "svg", "html":
+		ext = name
+	case "markdown":
+		ext = "md"
+	case "prometheus":
+		ext = "prom"
+	}
+	name := fmt.Sprintf("%v_%d", t.host, t.port)
+	if t.count > 1 {
+		name += "_" + t.addr.IP.String()
+	}
+	name = strings.NewReplacer("/", "_", ":", "_").Replace(name)
+	return filepath.Join(config.OutputDir, name+"."+ext)
+}
+
+// traceTarget traces one target of a batch. ndjson output is written to out
+// as it arrives, as every line names its target, other formats are sent to
+// output whole once the trace completes, or written to a file of their own
+// with -d.
+func traceTarget(opts tracetcp.Options, t target, listener *tracetcp.Listener, out io.Writer, output chan []byte) (*tracetcp.Result, error) {
+	opts.Target, opts.Port = t.host, t.port
+	if t.addr != nil {
+		opts.Addr, opts.AddrIndex, opts.AddrCount = t.addr, t.index, t.count
+	}
+	if t.unsupported || config.OutputDir != "" || config.OutputWriter == "ndjson" {
+		output <- nil
+	}
+	if t.unsupported {
+		return nil, nil
+	}
+
+	if config.OutputDir != "" {
+		f, err := os.Create(outputFileName(t))
+		if err != nil {
+			return nil, err
+		}
+		result, err := runTrace(context.Background(), opts, f, listener)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if result == nil {
+			// the trace never started, so there is nothing to keep
+			os.Remove(f.Name())
+		}
+		return result, err
+	}
+
+	if config.OutputWriter == "ndjson" {
+		return runTrace(context.Background(), opts, out, listener)
+	}
+
+	var buf bytes.Buffer
+	result, err := runTrace(context.Background(), opts, &buf, listener)
+	output <- buf.Bytes()
+	return result, err
+}
+
+// readTargetsFile reads the targets listed in config.Targets.
+func readTargetsFile() ([]target, error) {
+	if config.Targets == "-" {
+		return readTargets(os.Stdin, 80)
+	}
+	f, err := os.Open(config.Targets)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readTargets(f, 80)
+}
+
+// batch traces the addresses of targets chosen with -a, config.Jobs at a
+// time, writing their traces in the order of targets, then writes a summary
+// of which were reached to stderr. it exits with 1 if any target traced was not
+// reached.
+func batch(opts tracetcp.Options, targets []target) {
+	if outputWriterName() == "prometheus" && config.OutputWriter != "prometheus" {
+		// every trace would replace the one file in turn
+		exitOnError(fmt.Errorf("-o prometheus=FILE holds a single trace, use -d DIR -o prometheus to write a file for each target"))
+	}
+	targets = resolveTargets(targets)
+
+	if config.OutputDir != "" {
+		exitOnError(os.MkdirAll(config.OutputDir, 0755))
+	}
+	jobs := config.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	listener, err := tracetcp.NewListener()
+	exitOnError(err)
+	defer listener.Close()
+
+	out := &syncWriter{out: os.Stdout}
+	outputs := make([]chan []byte, len(targets))
+	for i := range outputs {
+		outputs[i] = make(chan []byte, 1)
+	}
+	written := make(chan struct{})
+	go func() {
+		for _, output := range outputs {
+			out.Write(<-output)
+		}
+		close(written)
+	}()
+
+	results := make([]*tracetcp.Result, len(targets))
+	errs := make([]error, len(targets))
+	running := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		running <- struct{}{}
+		wg.Add(1)
+		go func(i int, t target) {
+			defer wg.Done()
+			results[i], errs[i] = traceTarget(opts, t, listener, out, outputs[i])
+			<-running
+		}(i, t)
+	}
+	wg.Wait()
+	<-written
+
+	if !writeSummary(os.Stderr, targets, results, errs) {
+		os.Exit(1)
+	}
+}
+
+// writeSummary lists each target and whether it was reached, returning true
+// if all those traced were. targets that could not be traced are listed as
+// skipped.
+func writeSummary(w io.Writer, targets []target, results []*tracetcp.Result, errs []error) bool {
+	reached, skipped := 0, 0
+	var lines []string
+	for i, t := range targets {
+		r, err := results[i], errs[i]
+		switch {
+		case t.unsupported:
+			skipped++
+			lines = append(lines, fmt.Sprintf("skipped      %v  unsupported, only IPv4 addresses can be traced", t))
+		case r != nil && r.Reached:
+			reached++
+			lines = append(lines, fmt.Sprintf("reached      %v  %v", t, describeResult(r)))
+		case err != nil:
+			lines = append(lines, fmt.Sprintf("failed       %v  %v", t, err))
+		case r.Error != "":
+			lines = append(lines, fmt.Sprintf("failed       %v  %v", t, r.Error))
+		default:
+			lines = append(lines, fmt.Sprintf("not reached  %v  %v", t, describeResult(r)))
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d of %d targets reached", reached, len(targets)-skipped)
+	if skipped > 0 {
+		fmt.Fprintf(w, ", %d skipped", skipped)
+	}
+	fmt.Fprintln(w, ":")
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+	return reached == len(targets)-skipped
+}
+
+func describeResult(r *tracetcp.Result) string {
+	if r.Destination != nil {
+		return fmt.Sprintf("%v in %d hops", describeOutcome(r.Status, r.Destination.Addr), r.Destination.Hop)
+	}
+	hops := 0
+	if n := len(r.Hops); n > 0 {
+		hops = r.Hops[n-1].TTL
+	}
+	return fmt.Sprintf("%v after %d hops", r.Status, hops)
+}
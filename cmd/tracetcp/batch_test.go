@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0xcafed00d/assert"
+	"github.com/0xcafed00d/tracetcp-go/tracetcp"
+)
+
+func TestReadTargets(t *testing.T) {
+	assert := assert.Make(t)
+
+	targets, err := readTargets(strings.NewReader("# partners\nwww.example.com:443\n\n  10.0.0.1  \n"), 80)
+	assert(err).NoError()
+	assert(targets).Equal([]target{{host: "www.example.com", port: 443}, {host: "10.0.0.1", port: 80}})
+
+	_, err = readTargets(strings.NewReader("www.example.com\nwww.example.com:443:1\n"), 80)
+	assert(err).HasError()
+	assert(strings.HasPrefix(err.Error(), "Line 2:")).IsTrue()
+
+	_, err = readTargets(strings.NewReader("# nothing\n"), 80)
+	assert(err).HasError()
+}
+
+func TestWriteSummary(t *testing.T) {
+	assert := assert.Make(t)
+
+	reached := &tracetcp.Result{Status: tracetcp.Connected, Reached: true,
+		Destination: &tracetcp.Destination{Addr: net.ParseIP("10.0.0.1"), Hop: 7}}
+	unreached := &tracetcp.Result{Status: tracetcp.TimedOut, Hops: []tracetcp.Hop{{TTL: 1}, {TTL: 30}}}
+	targets := []target{{host: "a.example.com", port: 443}, {host: "b.example.com", port: 80}, {host: "c.example.com", port: 22}}
+
+	var buf bytes.Buffer
+	ok := writeSummary(&buf, targets, []*tracetcp.Result{reached, unreached, nil},
+		[]error{nil, nil, errors.New("no such host")})
+	assert(ok).IsFalse()
+	assert(buf.String()).Equal("\n1 of 3 targets reached:\n" +
+		"reached      a.example.com:443  Connected at 10.0.0.1 in 7 hops\n" +
+		"not reached  b.example.com:80  TimedOut after 30 hops\n" +
+		"failed       c.example.com:22  no such host\n")
+
+	buf.Reset()
+	assert(writeSummary(&buf, targets[:1], []*tracetcp.Result{reached}, []error{nil})).IsTrue()
+
+	// addresses that can not be traced are listed but do not fail the run
+	skipped := target{host: "a.example.com", port: 443, addr: &net.IPAddr{IP: net.ParseIP("2001:db8::1")}, index: 2, count: 2, unsupported: true}
+	buf.Reset()
+	assert(writeSummary(&buf, []target{targets[0], skipped}, []*tracetcp.Result{reached, nil}, []error{nil, nil})).IsTrue()
+	assert(buf.String()).Equal("\n1 of 1 targets reached, 1 skipped:\n" +
+		"reached      a.example.com:443  Connected at 10.0.0.1 in 7 hops\n" +
+		"skipped      a.example.com:443 2001:db8::1 (ipv6 address 2 of 2)  unsupported, only IPv4 addresses can be traced\n")
+}
+
+func TestChooseAddresses(t *testing.T) {
+	assert := assert.Make(t)
+
+	addrs := []*net.IPAddr{{IP: net.ParseIP("10.0.0.1")}, {IP: net.ParseIP("2001:db8::1")}, {IP: net.ParseIP("10.0.0.2")}}
+	host := target{host: "www.example.com", port: 443}
+
+	chosen, err := chooseAddresses(host, addrs, "")
+	assert(err).NoError()
+	assert(len(chosen), chosen[0].addr, chosen[0].index, chosen[0].count).Equal(1, addrs[0], 1, 3)
+
+	chosen, err = chooseAddresses(host, addrs[1:], "")
+	assert(err).NoError()
+	assert(len(chosen), chosen[0].String()).Equal(1, "www.example.com:443 10.0.0.2 (ipv4 address 2 of 2)")
+
+	_, err = chooseAddresses(host, addrs, "2")
+	assert(err).HasError()
+
+	chosen, err = chooseAddresses(host, addrs, "all")
+	assert(err).NoError()
+	assert(len(chosen), chosen[2].index, chosen[2].String()).Equal(3, 3, "www.example.com:443 10.0.0.2 (ipv4 address 3 of 3)")
+	assert(chosen[0].unsupported, chosen[1].unsupported, chosen[2].unsupported).Equal(false, true, false)
+
+	_, err = chooseAddresses(host, addrs[1:2], "all")
+	assert(err).HasError()
+
+	_, err = chooseAddresses(host, addrs, "4")
+	assert(err).HasError()
+
+	assert(validAddressChoice("all"), validAddressChoice("2"), validAddressChoice("")).NoError()
+	assert(validAddressChoice("0")).HasError()
+	assert(validAddressChoice("some")).HasError()
+}
+
+func TestOutputFileName(t *testing.T) {
+	assert := assert.Make(t)
+	defer func(saved Config) { config = saved }(config)
+
+	config.OutputDir = "traces"
+	one := target{host: "www.example.com", port: 443, count: 1}
+	for format, name := range map[string]string{
+		"std":                  "www.example.com_443.txt",
+		"json":                 "www.example.com_443.json",
+		"markdown":             "www.example.com_443.md",
+		"prometheus":           "www.example.com_443.prom",
+		"template=hops.tmpl":   "www.example.com_443.txt",
+		"template:{{.Target}}": "www.example.com_443.txt",
+	} {
+		config.OutputWriter = format
+		assert(outputFileName(one)).Equal(filepath.Join("traces", name))
+	}
+
+	second := target{host: "www.example.com", port: 443, addr: &net.IPAddr{IP: net.ParseIP("10.0.0.2")}, count: 2}
+	config.OutputWriter = "json"
+	assert(outputFileName(second)).Equal(filepath.Join("traces", "www.example.com_443_10.0.0.2.json"))
+}
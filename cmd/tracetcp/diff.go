@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/0xcafed00d/tracetcp-go/tracetcp"
+)
+
+// loadResult reads a trace saved with -o json or -o ndjson.
+func loadResult(name string) (*tracetcp.Result, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	opts, events, err := tracetcp.ReadTrace(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+	result := tracetcp.NewResult(opts)
+	for _, ev := range events {
+		result.Add(ev)
+	}
+	return result, nil
+}
+
+func describeOutcome(status tracetcp.TraceEventType, dest fmt.Stringer) string {
+	if status == tracetcp.Connected || status == tracetcp.RemoteClosed {
+		return fmt.Sprintf("%v at %v", status, dest)
+	}
+	return status.String()
+}
+
+// diff compares two saved traces. it exits with 1 if the path changed, as
+// diff(1) does, and 2 on error.
+func diff(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	jsonOut := flags.Bool("json", false, "write the differences as JSON")
+	threshold := flags.Duration("r", tracetcp.DefaultRTTThreshold, "report hops whose average RTT changed by more than this")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: tracetcp-go diff [options] old.json new.json")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var results [2]*tracetcp.Result
+	for i, name := range flags.Args() {
+		r, err := loadResult(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		results[i] = r
+	}
+
+	d := tracetcp.DiffResults(results[0], results[1], *threshold)
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(d)
+	} else {
+		for i, prefix := range []string{"---", "+++"} {
+			r := results[i]
+			fmt.Printf("%v %v  %v (%v) port %v  %v\n", prefix, flags.Arg(i),
+				r.Target, r.Addr, r.Port, r.Started.Format(time.RFC3339))
+		}
+		for _, h := range d.Hops {
+			fmt.Println(h)
+		}
+		if d.OutcomeChanged {
+			fmt.Printf("outcome: %v -> %v\n",
+				describeOutcome(d.OldStatus, d.OldDestination), describeOutcome(d.NewStatus, d.NewDestination))
+		}
+		if d.PathChanged {
+			fmt.Println("path changed")
+		} else {
+			fmt.Println("path unchanged")
+		}
+	}
+
+	if d.PathChanged {
+		os.Exit(1)
+	}
+}
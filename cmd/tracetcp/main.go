@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"time"
 
@@ -15,11 +18,26 @@ type Config struct {
 	Help         bool
 	Timeout      time.Duration
 	NoLookups    bool
+	ASNLookups   bool
 	StartHop     int
 	EndHop       int
 	Queries      int
 	Verbose      bool
 	OutputWriter string
+	ProbeType    string
+	TCPOptions   string
+	FastOpen     bool
+	Fingerprints string
+	Source       string
+	Watch        time.Duration
+	Baseline     string
+	OnChange     string
+	Webhook      string
+	RTTChange    time.Duration
+	Targets      string
+	Jobs         int
+	OutputDir    string
+	Addresses    string
 }
 
 var config Config
@@ -28,14 +46,32 @@ func init() {
 	flag.BoolVar(&config.Help, "?", false, "display help")
 	flag.DurationVar(&config.Timeout, "t", time.Second, "probe reply timeout")
 	flag.BoolVar(&config.NoLookups, "n", false, "no reverse DNS lookups")
+	flag.BoolVar(&config.ASNLookups, "asn", false, "look up the origin AS of public addresses for the dot and graphml formats")
 	flag.IntVar(&config.StartHop, "h", 1, "start hop")
 	flag.IntVar(&config.EndHop, "m", 30, "max hops")
 	flag.IntVar(&config.Queries, "p", 3, "pings per hop")
 	flag.BoolVar(&config.Verbose, "v", false, "verbose output")
-	flag.StringVar(&config.OutputWriter, "o", "std", "output format: [std|json]")
+	flag.StringVar(&config.OutputWriter, "o", "std", "output format: "+tracetcp.OutputWriterHelp())
+	flag.StringVar(&config.ProbeType, "P", "connect", "probe type: [connect|syn]")
+	flag.BoolVar(&config.FastOpen, "F", false, "test TCP Fast Open at the destination, with -P syn")
+	flag.StringVar(&config.Source, "s", "", "source address to send probes from")
+	flag.StringVar(&config.Fingerprints, "S", "", "file of extra SYN-ACK signatures in p0f format")
+	flag.DurationVar(&config.Watch, "watch", 0, "trace again at this interval, alerting when the path changes")
+	flag.StringVar(&config.Baseline, "baseline", "", "saved json trace to compare watched traces with")
+	flag.StringVar(&config.OnChange, "on-change", "", "command run with the alert as JSON on stdin when a watched path changes")
+	flag.StringVar(&config.Webhook, "webhook", "", "URL the alert is POSTed to as JSON when a watched path changes")
+	flag.DurationVar(&config.RTTChange, "rtt-change", tracetcp.DefaultRTTThreshold, "change in a hop's average RTT that alerts when watching")
+	flag.StringVar(&config.Targets, "f", "", "file of hostname[:port] lines to trace, or - for stdin")
+	flag.IntVar(&config.Jobs, "j", 10, "number of targets from -f traced at once")
+	flag.StringVar(&config.OutputDir, "d", "", "directory to write each target's trace from -f to")
+	flag.StringVar(&config.Addresses, "a", "", "address of the host to trace to: all, or N for the Nth it resolves to (default first IPv4). only IPv4 addresses can be traced, IPv6 ones are skipped")
+	flag.StringVar(&config.TCPOptions, "O", "", "TCP options sent on probes, e.g. mss=1460,sack,ts,nop,wscale=7,tfo,mptcp")
 
 	flag.Usage = func() {
 		fmt.Fprintln(os.Stderr, "Usage: tracetcp-go [options] hostname[:port]")
+		fmt.Fprintln(os.Stderr, "       tracetcp-go [options] -f targets.txt")
+		fmt.Fprintln(os.Stderr, "       tracetcp-go render [options] [file]")
+		fmt.Fprintln(os.Stderr, "       tracetcp-go diff [options] old.json new.json")
 		flag.PrintDefaults()
 	}
 }
@@ -49,6 +85,17 @@ func exitOnError(err error) {
 
 // Linux to open raw sockets without running as root: sudo setcap cap_net_raw=ep tracetcp
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "render":
+			render(os.Args[2:])
+			return
+		case "diff":
+			diff(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Parse()
 
 	if len(flag.Args()) == 0 && config.Help {
@@ -56,40 +103,158 @@ func main() {
 		os.Exit(1)
 	}
 
-	if len(flag.Args()) != 1 {
+	if config.Targets != "" && len(flag.Args()) != 0 {
+		exitOnError(fmt.Errorf("Hosts are read from %v, not the command line", config.Targets))
+	}
+	if config.Targets == "" && len(flag.Args()) != 1 {
 		fmt.Fprintln(os.Stderr, "Host not suplied")
 		fmt.Fprintln(os.Stderr, "")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	host, port, err := tracetcp.SplitHostAndPort(flag.Args()[0], 80)
+	exitOnError(validAddressChoice(config.Addresses))
+	if config.Watch > 0 && (config.Targets != "" || config.Addresses == "all") {
+		exitOnError(fmt.Errorf("Only one address can be watched"))
+	}
+
+	var err error
+	var targets []target
+	if config.Targets != "" {
+		targets, err = readTargetsFile()
+	} else {
+		host, port, serr := tracetcp.SplitHostAndPort(flag.Args()[0], 80)
+		targets, err = []target{{host: host, port: port}}, serr
+	}
 	exitOnError(err)
 
-	ip, err := tracetcp.LookupAddress(host)
+	opts := tracetcp.DefaultOptions
+	opts.StartHop = config.StartHop
+	opts.EndHop = config.EndHop
+	opts.Queries = config.Queries
+	opts.Timeout = config.Timeout
+	opts.FastOpen = config.FastOpen
+
+	opts.ProbeType, err = tracetcp.ParseProbeType(config.ProbeType)
 	exitOnError(err)
 
-	trace := tracetcp.NewTrace()
-	trace.BeginTrace(ip, port, config.StartHop, config.EndHop, config.Queries, config.Timeout)
+	opts.TCPOptions, err = tracetcp.ParseTCPOptionSpec(config.TCPOptions)
+	exitOnError(err)
+
+	if config.Source != "" {
+		opts.Source = net.ParseIP(config.Source)
+		if opts.Source == nil {
+			exitOnError(fmt.Errorf("Invalid source address: %v", config.Source))
+		}
+	}
+
+	if config.Fingerprints != "" {
+		opts.Fingerprints, err = tracetcp.LoadFingerprintDB(config.Fingerprints)
+		exitOnError(err)
+	}
 
 	if !config.Verbose {
 		log.SetOutput(ioutil.Discard)
 	}
 
-	writer, err := tracetcp.GetOutputWriter(config.OutputWriter)
+	if config.Targets != "" || config.Addresses == "all" {
+		batch(opts, targets)
+		return
+	}
+
+	opts.Target, opts.Port = targets[0].host, targets[0].port
+	if config.Watch > 0 {
+		watch(opts)
+		return
+	}
+	_, err = runTrace(context.Background(), opts, os.Stdout, nil)
 	exitOnError(err)
+}
+
+// errWriter keeps the first error writing to out, as not every output format
+// reports a failed write.
+type errWriter struct {
+	out io.Writer
+	err error
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.out.Write(p)
+	w.err = err
+	return n, err
+}
 
-	writer.Init(port, config.StartHop, config.EndHop, config.Queries, config.NoLookups, os.Stdout)
+// runTrace traces the route to the target, writing the trace to out in the
+// chosen output format, and returns the result. replies are received by
+// listener if it is not nil.
+func runTrace(ctx context.Context, opts tracetcp.Options, out io.Writer, listener *tracetcp.Listener) (*tracetcp.Result, error) {
+	if opts.Addr == nil {
+		if err := lookupTarget(&opts); err != nil {
+			return nil, err
+		}
+	}
 
+	writer, err := tracetcp.GetOutputWriter(config.OutputWriter)
+	if err != nil {
+		return nil, err
+	}
+
+	trace := tracetcp.NewTrace()
+	trace.Listener = listener
+	if err := trace.Start(opts); err != nil {
+		return nil, err
+	}
+
+	dst := &errWriter{out: out}
+	err = writer.Init(tracetcp.OutputConfig{Options: opts, NoLookups: config.NoLookups, ASNLookups: config.ASNLookups, Out: dst})
+	if err != nil {
+		trace.AbortTrace()
+	}
+
+	result := tracetcp.NewResult(opts)
+	cancelled := ctx.Done()
 	for {
-		ev := <-trace.Events
-		writer.Event(ev)
+		var ev tracetcp.TraceEvent
+		select {
+		case <-cancelled:
+			// the trace stops before its next probe and completes as aborted
+			trace.AbortTrace()
+			cancelled = nil
+			continue
+		case ev = <-trace.Events:
+		}
+		result.Add(ev)
 
 		if config.Verbose {
 			fmt.Println(ev)
 		}
+		if err != nil {
+			if ev.Type == tracetcp.TraceComplete {
+				return result, err
+			}
+			continue
+		}
+
+		if err = writer.Event(ev); err == nil {
+			err = dst.err
+		}
+		if err != nil {
+			// the output is lost, so the trace stops and the first error is
+			// returned once it completes
+			trace.AbortTrace()
+		}
 		if ev.Type == tracetcp.TraceComplete {
-			break
+			// writers that need the whole trace write it now
+			if err == nil {
+				err = writer.Close()
+			}
+			if err == nil {
+				err = dst.err
+			}
+			return result, err
 		}
 	}
 }
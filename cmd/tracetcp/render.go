@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/0xcafed00d/tracetcp-go/tracetcp"
+)
+
+// render writes a trace saved with -o json or -o ndjson in another output
+// format, without tracing again.
+func render(args []string) {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	output := flags.String("o", "std", "output format: "+tracetcp.OutputWriterHelp())
+	lookups := flags.Bool("l", false, "reverse DNS lookups of the addresses in the trace")
+	asn := flags.Bool("asn", false, "look up the origin AS of public addresses for the dot and graphml formats")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: tracetcp-go render [options] [trace.json|trace.ndjson|-]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	var in io.Reader = os.Stdin
+	if name := flags.Arg(0); name != "" && name != "-" {
+		f, err := os.Open(name)
+		exitOnError(err)
+		defer f.Close()
+		in = f
+	}
+
+	opts, events, err := tracetcp.ReadTrace(in)
+	exitOnError(err)
+
+	writer, err := tracetcp.GetOutputWriter(*output)
+	exitOnError(err)
+
+	err = writer.Init(tracetcp.OutputConfig{Options: opts, NoLookups: !*lookups, ASNLookups: *asn, Out: os.Stdout})
+	exitOnError(err)
+
+	for _, ev := range events {
+		err = writer.Event(ev)
+		if ev.Type == tracetcp.TraceComplete {
+			exitOnError(err)
+		}
+	}
+	exitOnError(writer.Close())
+}
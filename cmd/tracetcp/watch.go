@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xcafed00d/tracetcp-go/tracetcp"
+)
+
+// how long an alert command or webhook is given to complete
+const alertTimeout = 30 * time.Second
+
+// Alert is sent to the -on-change command and -webhook URL when a watched
+// trace differs from the one before it, or from the baseline on the first
+// trace.
+type Alert struct {
+	Target  string           `json:"target"`
+	Port    int              `json:"port"`
+	Time    time.Time        `json:"time"`
+	Reasons []string         `json:"reasons"`
+	Result  *tracetcp.Result `json:"result"`
+
+	// differences from the previous trace and the baseline
+	Previous *tracetcp.ResultDiff `json:"previous,omitempty"`
+	Baseline *tracetcp.ResultDiff `json:"baseline,omitempty"`
+}
+
+// alertReasons returns why d is worth an alert, if it is.
+func alertReasons(d *tracetcp.ResultDiff) []string {
+	var reasons []string
+	if d.PathChanged {
+		reasons = append(reasons, "path")
+	}
+	for _, h := range d.Hops {
+		if h.RTTChanged {
+			reasons = append(reasons, "latency")
+			break
+		}
+	}
+	return reasons
+}
+
+// runAlertCommand runs command with the shell, passing the alert as JSON on
+// its standard input.
+func runAlertCommand(command string, alert *Alert, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"TRACETCP_TARGET="+alert.Target,
+		"TRACETCP_PORT="+strconv.Itoa(alert.Port),
+		"TRACETCP_REASONS="+strings.Join(alert.Reasons, ","))
+	return cmd.Run()
+}
+
+// postWebhook POSTs the alert as JSON to url.
+func postWebhook(url string, payload []byte) error {
+	client := http.Client{Timeout: alertTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webhook %v returned %v", url, resp.Status)
+	}
+	return nil
+}
+
+func sendAlert(alert *Alert) {
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%v: %v port %v changed: %v\n",
+		alert.Time.Format(time.RFC3339), alert.Target, alert.Port, alert.Reasons)
+
+	if config.OnChange != "" {
+		if err := runAlertCommand(config.OnChange, alert, payload); err != nil {
+			fmt.Fprintln(os.Stderr, "Alert command failed:", err)
+		}
+	}
+	if config.Webhook != "" {
+		if err := postWebhook(config.Webhook, payload); err != nil {
+			fmt.Fprintln(os.Stderr, "Alert webhook failed:", err)
+		}
+	}
+}
+
+// watch traces the route every config.Watch until interrupted, alerting when
+// the path or latency changes.
+func watch(opts tracetcp.Options) {
+	var baseline, previous *tracetcp.Result
+	if config.Baseline != "" {
+		var err error
+		baseline, err = loadResult(config.Baseline)
+		exitOnError(err)
+	}
+
+	// an interrupt aborts the trace running, if any, then stops watching
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+	ticker := time.NewTicker(config.Watch)
+	defer ticker.Stop()
+
+	for {
+		result, err := runTrace(ctx, opts, os.Stdout, nil)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if result != nil && result.Status != tracetcp.TraceFailed {
+			alert := &Alert{Target: result.Target, Port: result.Port, Time: result.Finished, Result: result}
+			if baseline != nil {
+				alert.Baseline = tracetcp.DiffResults(baseline, result, config.RTTChange)
+			}
+			if previous != nil {
+				alert.Previous = tracetcp.DiffResults(previous, result, config.RTTChange)
+				alert.Reasons = alertReasons(alert.Previous)
+			} else if alert.Baseline != nil {
+				alert.Reasons = alertReasons(alert.Baseline)
+			}
+			if len(alert.Reasons) > 0 {
+				sendAlert(alert)
+			}
+
+			previous = result
+			if baseline == nil {
+				baseline = result
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
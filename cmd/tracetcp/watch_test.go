@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xcafed00d/assert"
+	"github.com/0xcafed00d/tracetcp-go/tracetcp"
+)
+
+func TestAlertReasons(t *testing.T) {
+	assert := assert.Make(t)
+
+	assert(alertReasons(&tracetcp.ResultDiff{})).Equal([]string(nil))
+	assert(alertReasons(&tracetcp.ResultDiff{PathChanged: true, Hops: []tracetcp.HopDiff{{RTTChanged: true}}})).
+		Equal([]string{"path", "latency"})
+}
+
+func TestPostWebhook(t *testing.T) {
+	assert := assert.Make(t)
+
+	var received Alert
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	payload, _ := json.Marshal(&Alert{Target: "www.example.com", Port: 443, Reasons: []string{"path"}})
+	assert(postWebhook(server.URL, payload)).NoError()
+	assert(contentType, received.Target, received.Port, received.Reasons).
+		Equal("application/json", "www.example.com", 443, []string{"path"})
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	assert(postWebhook(failing.URL, payload)).HasError()
+}
+
+func TestRunAlertCommand(t *testing.T) {
+	assert := assert.Make(t)
+
+	dir, err := ioutil.TempDir("", "tracetcp")
+	assert(err).NoError()
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "alert")
+	alert := &Alert{Target: "www.example.com", Port: 443, Reasons: []string{"path", "latency"}}
+	assert(runAlertCommand(`(echo "$TRACETCP_TARGET $TRACETCP_PORT $TRACETCP_REASONS"; cat) > `+out, alert, []byte("{}"))).NoError()
+
+	data, err := ioutil.ReadFile(out)
+	assert(err).NoError()
+	assert(string(data)).Equal("www.example.com 443 path,latency\n{}")
+
+	assert(runAlertCommand("exit 3", alert, nil)).HasError()
+}
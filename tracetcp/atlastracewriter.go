@@ -0,0 +1,117 @@
+package tracetcp
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+)
+
+// AtlasTraceWriter writes the trace as a RIPE Atlas traceroute result, one
+// JSON object per line so that several traces can be appended to a file.
+// the measurement and probe IDs of a real Atlas result are left out.
+type AtlasTraceWriter struct {
+	resultWriter
+}
+
+func (w *AtlasTraceWriter) Init(config OutputConfig) error {
+	w.render = w.writeAtlas
+	return w.resultWriter.Init(config)
+}
+
+// jsonMillis is a duration in milliseconds written with three decimals, as
+// both Atlas and scamper do.
+type jsonMillis float64
+
+func (m jsonMillis) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatFloat(float64(m), 'f', 3, 64)), nil
+}
+
+type atlasReply struct {
+	X     string      `json:"x,omitempty"`
+	From  net.IP      `json:"from,omitempty"`
+	RTT   *jsonMillis `json:"rtt,omitempty"`
+	TTL   int         `json:"ttl,omitempty"`
+	Err   interface{} `json:"err,omitempty"`
+	Flags string      `json:"flags,omitempty"`
+}
+
+type atlasHop struct {
+	Hop    int          `json:"hop"`
+	Result []atlasReply `json:"result"`
+}
+
+type atlasResult struct {
+	AF        int        `json:"af"`
+	DstAddr   net.IP     `json:"dst_addr"`
+	DstName   string     `json:"dst_name"`
+	EndTime   int64      `json:"endtime"`
+	From      net.IP     `json:"from,omitempty"`
+	MsmName   string     `json:"msm_name"`
+	ParisID   int        `json:"paris_id"`
+	Proto     string     `json:"proto"`
+	Result    []atlasHop `json:"result"`
+	Size      int        `json:"size"`
+	SrcAddr   net.IP     `json:"src_addr,omitempty"`
+	Timestamp int64      `json:"timestamp"`
+	Type      string     `json:"type"`
+}
+
+// atlasUnreachable returns the err value Atlas records for an ICMP
+// destination unreachable code: a letter for the common codes, else the code.
+func atlasUnreachable(code int) interface{} {
+	switch code {
+	case 0:
+		return "N"
+	case 1:
+		return "H"
+	case 2:
+		return "P"
+	case 3:
+		return "p"
+	case 13:
+		return "A"
+	}
+	return code
+}
+
+func (w *AtlasTraceWriter) writeAtlas(r *Result) error {
+	ar := atlasResult{
+		AF:        4,
+		DstAddr:   r.Addr,
+		DstName:   r.Target,
+		EndTime:   r.Finished.Unix(),
+		From:      r.LocalAddr,
+		MsmName:   "Traceroute",
+		Proto:     "TCP",
+		Result:    []atlasHop{},
+		Size:      w.config.probeSize(),
+		SrcAddr:   r.LocalAddr,
+		Timestamp: r.Started.Unix(),
+		Type:      "traceroute",
+	}
+
+	for _, h := range r.Hops {
+		hop := atlasHop{Hop: h.TTL}
+		for _, p := range h.Probes {
+			if !p.Replied() {
+				hop.Result = append(hop.Result, atlasReply{X: "*"})
+				continue
+			}
+			// set for every reply, so a reply timed at 0 is not read as lost
+			rtt := jsonMillis(millis(p.RTT))
+			reply := atlasReply{From: p.Addr, RTT: &rtt, TTL: p.ReplyTTL}
+			switch p.Outcome {
+			case Unreachable:
+				reply.Err = atlasUnreachable(p.ICMPCode)
+			case Connected:
+				reply.Flags = "SA"
+			case RemoteClosed:
+				reply.Flags = "RA"
+			}
+			hop.Result = append(hop.Result, reply)
+		}
+		ar.Result = append(ar.Result, hop)
+	}
+
+	return json.NewEncoder(w.config.Out).Encode(ar)
+}
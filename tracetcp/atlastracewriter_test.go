@@ -0,0 +1,40 @@
+package tracetcp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/0xcafed00d/assert"
+)
+
+func TestAtlasTraceWriterGolden(t *testing.T) {
+	defer fixedClock()()
+
+	w := &AtlasTraceWriter{}
+	w.lookup = testLookup
+	checkGolden(t, "connected.atlas.json", writeGraphTrace(w, testTraceEvents()))
+
+	w = &AtlasTraceWriter{}
+	w.lookup = testLookup
+	checkGolden(t, "unreachable.atlas.json", writeGraphTrace(w, unreachableTraceEvents()))
+
+	// a reply timed at 0, as on loopback, still has an rtt
+	events := testTraceEvents()
+	events[1].Time = 0
+	w = &AtlasTraceWriter{}
+	w.lookup = testLookup
+	out := string(writeGraphTrace(w, events))
+	assert.Make(t)(strings.Contains(out, `{"from":"10.0.0.1","rtt":0.000,`)).IsTrue()
+}
+
+func TestScamperTraceWriterGolden(t *testing.T) {
+	defer fixedClock()()
+
+	w := &ScamperTraceWriter{}
+	w.lookup = testLookup
+	checkGolden(t, "connected.scamper.json", writeGraphTrace(w, testTraceEvents()))
+
+	w = &ScamperTraceWriter{}
+	w.lookup = testLookup
+	checkGolden(t, "unreachable.scamper.json", writeGraphTrace(w, unreachableTraceEvents()))
+}
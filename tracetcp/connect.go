@@ -49,6 +49,10 @@ type connectEvent struct {
 	ttl        int
 	query      int
 	err        error
+
+	// set for raw SYN probes, where the exact header sent is known
+	sequence    uint32
+	sentOptions TCPOptions
 }
 
 // implementation of fmt.Stinger interface
@@ -70,7 +74,7 @@ func makeEvent(event *connectEvent, evtype connectEventType) connectEvent {
 	return *event
 }
 
-func tryConnect(dest net.IPAddr, port, ttl, query int, timeout time.Duration) (result connectEvent) {
+func tryConnect(dest net.IPAddr, port, ttl, query int, timeout time.Duration, source net.IPAddr) (result connectEvent) {
 
 	log.Printf("try Connect dest: %v port: %v ttl: %v query: %v timeout: %v",
 		dest, port, ttl, query, timeout)
@@ -90,6 +94,14 @@ func tryConnect(dest net.IPAddr, port, ttl, query int, timeout time.Duration) (r
 	}
 	defer syscall.Close(sock)
 
+	if source.IP != nil {
+		err = syscall.Bind(sock, ToSockaddrInet4(source, 0))
+		if err != nil {
+			result = makeErrorEvent(&event, err)
+			return
+		}
+	}
+
 	err = syscall.SetsockoptInt(sock, 0x0, syscall.IP_TTL, ttl)
 	if err != nil {
 		result = makeErrorEvent(&event, err)
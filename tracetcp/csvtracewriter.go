@@ -0,0 +1,92 @@
+package tracetcp
+
+import (
+	"encoding/csv"
+	"net"
+	"strconv"
+	"time"
+)
+
+var csvHeader = []string{"timestamp", "target", "port", "hop", "query", "outcome", "addr", "name", "rtt_ms"}
+
+// CSVTraceWriter writes one row per probe as it completes. Comma is the field
+// separator, ',' for csv or '\t' for tsv.
+type CSVTraceWriter struct {
+	Comma rune
+
+	config OutputConfig
+	out    *csv.Writer
+	names  map[string]string
+	now    func() time.Time
+	lookup func(ip net.IPAddr) (string, error)
+}
+
+func (w *CSVTraceWriter) Init(config OutputConfig) error {
+	w.config = config
+	w.out = csv.NewWriter(config.Out)
+	if w.Comma != 0 {
+		w.out.Comma = w.Comma
+	}
+	w.names = map[string]string{}
+	if w.now == nil {
+		w.now = time.Now
+	}
+	if w.lookup == nil {
+		w.lookup = ReverseLookup
+	}
+
+	w.out.Write(csvHeader)
+	w.out.Flush()
+	return w.out.Error()
+}
+
+func (w *CSVTraceWriter) Close() error {
+	w.out.Flush()
+	return w.out.Error()
+}
+
+func (w *CSVTraceWriter) Event(e TraceEvent) error {
+	switch e.Type {
+	case TimedOut, TTLExpired, Connected, RemoteClosed, Unreachable:
+	default:
+		return nil
+	}
+
+	var addr, name, rtt string
+	if e.Type != TimedOut {
+		addr = e.Addr.IP.String()
+		name = w.name(e.Addr)
+		rtt = strconv.FormatFloat(float64(e.Time)/float64(time.Millisecond), 'f', 3, 64)
+	}
+
+	stamp := e.Sent
+	if stamp.IsZero() {
+		stamp = w.now()
+	}
+	w.out.Write([]string{
+		stamp.UTC().Format(time.RFC3339Nano),
+		w.config.Target,
+		strconv.Itoa(w.config.Port),
+		strconv.Itoa(e.Hop),
+		strconv.Itoa(e.Query),
+		e.Type.String(),
+		addr,
+		name,
+		rtt,
+	})
+	w.out.Flush()
+	return w.out.Error()
+}
+
+func (w *CSVTraceWriter) name(addr net.IPAddr) string {
+	if w.config.NoLookups {
+		return ""
+	}
+	key := addr.String()
+	if name, ok := w.names[key]; ok {
+		return name
+	}
+	name, _ := w.lookup(addr)
+	w.names[key] = name
+	return name
+}
@@ -0,0 +1,48 @@
+package tracetcp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/0xcafed00d/assert"
+)
+
+func writeCSVTrace(comma rune, opts Options, events []TraceEvent) string {
+	var out bytes.Buffer
+	w := &CSVTraceWriter{
+		Comma:  comma,
+		now:    func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) },
+		lookup: testLookup,
+	}
+	w.Init(OutputConfig{Options: opts, Out: &out})
+	for _, e := range events {
+		w.Event(e)
+	}
+	w.Close()
+	return out.String()
+}
+
+func TestCSVTraceWriter(t *testing.T) {
+	assert := assert.Make(t)
+
+	assert(writeCSVTrace(',', testOptions(), testTraceEvents())).Equal(
+		"timestamp,target,port,hop,query,outcome,addr,name,rtt_ms\n" +
+			"2020-01-02T03:04:05Z,test.example.com,80,1,0,TTLExpired,10.0.0.1,router1.example.net,1.000\n" +
+			"2020-01-02T03:04:05Z,test.example.com,80,1,1,TTLExpired,10.0.0.2,,3.000\n" +
+			"2020-01-02T03:04:05Z,test.example.com,80,1,2,TimedOut,,,\n" +
+			"2020-01-02T03:04:05Z,test.example.com,80,2,0,TimedOut,,,\n" +
+			"2020-01-02T03:04:05Z,test.example.com,80,3,0,Connected,10.0.0.9,test.example.com,5.000\n")
+
+	opts := testOptions()
+	opts.Target = "odd,\"name\"\there"
+	lines := bytes.Split([]byte(writeCSVTrace('\t', opts, testTraceEvents())), []byte("\n"))
+	assert(string(lines[0])).Equal("timestamp\ttarget\tport\thop\tquery\toutcome\taddr\tname\trtt_ms")
+	assert(string(lines[1])).Equal("2020-01-02T03:04:05Z\t\"odd,\"\"name\"\"\there\"\t80\t1\t0\tTTLExpired\t10.0.0.1\trouter1.example.net\t1.000")
+
+	// rows are stamped with when the probe was sent, if known
+	events := testTraceEvents()[:2]
+	events[1].Sent = time.Date(2020, 1, 2, 3, 4, 0, 250000000, time.UTC)
+	lines = bytes.Split([]byte(writeCSVTrace(',', testOptions(), events)), []byte("\n"))
+	assert(string(lines[1])).Equal("2020-01-02T03:04:00.25Z,test.example.com,80,1,0,TTLExpired,10.0.0.1,router1.example.net,1.000")
+}
@@ -0,0 +1,216 @@
+package tracetcp
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HopChangeType is how the routers answering at a hop changed between two
+// traces.
+type HopChangeType int
+
+const (
+	HopUnchanged HopChangeType = iota
+	HopAdded
+	HopRemoved
+	HopUnresponsive
+	HopResponsive
+	HopChanged
+)
+
+// implementation of fmt.Stinger interface
+func (t HopChangeType) String() string {
+	switch t {
+	case HopUnchanged:
+		return "unchanged"
+	case HopAdded:
+		return "added"
+	case HopRemoved:
+		return "removed"
+	case HopUnresponsive:
+		return "unresponsive"
+	case HopResponsive:
+		return "responsive"
+	case HopChanged:
+		return "changed"
+	}
+	return "Invalid HopChangeType"
+}
+
+// implementation of encoding.TextMarshaler interface
+func (t HopChangeType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// HopDiff is the difference at one hop between two traces. RTTs are the
+// averages of the replies at the hop.
+type HopDiff struct {
+	TTL    int           `json:"ttl"`
+	Change HopChangeType `json:"change"`
+
+	Old     []net.IP `json:"old"`
+	New     []net.IP `json:"new"`
+	Added   []net.IP `json:"added,omitempty"`
+	Removed []net.IP `json:"removed,omitempty"`
+
+	RTTChanged bool          `json:"rtt_changed"`
+	OldRTT     time.Duration `json:"old_rtt_ns,omitempty"`
+	NewRTT     time.Duration `json:"new_rtt_ns,omitempty"`
+}
+
+// implementation of fmt.Stinger interface
+func (d HopDiff) String() string {
+	var parts []string
+	switch d.Change {
+	case HopAdded:
+		parts = append(parts, "added "+joinIPs(d.New, "*"))
+	case HopRemoved:
+		parts = append(parts, "removed, was "+joinIPs(d.Old, "*"))
+	case HopUnresponsive:
+		parts = append(parts, "unresponsive, was "+joinIPs(d.Old, "*"))
+	case HopResponsive:
+		parts = append(parts, "now answered by "+joinIPs(d.New, "*"))
+	case HopChanged:
+		var changes []string
+		for _, ip := range d.Removed {
+			changes = append(changes, "-"+ip.String())
+		}
+		for _, ip := range d.Added {
+			changes = append(changes, "+"+ip.String())
+		}
+		parts = append(parts, "changed "+strings.Join(changes, " "))
+	}
+	if d.RTTChanged {
+		parts = append(parts, fmt.Sprintf("rtt %.1f ms -> %.1f ms", millis(d.OldRTT), millis(d.NewRTT)))
+	}
+	return fmt.Sprintf("hop %d: %v", d.TTL, strings.Join(parts, ", "))
+}
+
+func joinIPs(ips []net.IP, none string) string {
+	if len(ips) == 0 {
+		return none
+	}
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// ResultDiff is the difference between two traces of the same target.
+type ResultDiff struct {
+	Hops []HopDiff `json:"hops"`
+
+	OldStatus      TraceEventType `json:"old_status"`
+	NewStatus      TraceEventType `json:"new_status"`
+	OldDestination net.IP         `json:"old_destination,omitempty"`
+	NewDestination net.IP         `json:"new_destination,omitempty"`
+	OutcomeChanged bool           `json:"outcome_changed"`
+
+	// set if any hop's responders or the outcome changed. RTT changes alone
+	// do not change the path.
+	PathChanged bool `json:"path_changed"`
+}
+
+// DefaultRTTThreshold is the change in a hop's average RTT that DiffResults
+// reports by default.
+const DefaultRTTThreshold = 20 * time.Millisecond
+
+// DiffResults aligns two traces hop by hop and reports the hops whose
+// responders changed between before and after, or whose average RTT changed by
+// more than rttThreshold. hops that only one trace reached are reported if
+// anything answered at them.
+func DiffResults(before, after *Result, rttThreshold time.Duration) *ResultDiff {
+	d := &ResultDiff{
+		Hops:      []HopDiff{},
+		OldStatus: before.Status,
+		NewStatus: after.Status,
+	}
+	if before.Destination != nil {
+		d.OldDestination = before.Destination.Addr
+	}
+	if after.Destination != nil {
+		d.NewDestination = after.Destination.Addr
+	}
+	d.OutcomeChanged = d.OldStatus != d.NewStatus || !d.OldDestination.Equal(d.NewDestination)
+
+	hops := map[int][2]*Hop{}
+	var ttls []int
+	for i, r := range []*Result{before, after} {
+		for j := range r.Hops {
+			h := &r.Hops[j]
+			pair, seen := hops[h.TTL]
+			if !seen {
+				ttls = append(ttls, h.TTL)
+			}
+			pair[i] = h
+			hops[h.TTL] = pair
+		}
+	}
+	sort.Ints(ttls)
+
+	for _, ttl := range ttls {
+		hd := diffHop(ttl, hops[ttl][0], hops[ttl][1], rttThreshold)
+		if hd.Change != HopUnchanged || hd.RTTChanged {
+			d.Hops = append(d.Hops, hd)
+		}
+		if hd.Change != HopUnchanged {
+			d.PathChanged = true
+		}
+	}
+	d.PathChanged = d.PathChanged || d.OutcomeChanged
+	return d
+}
+
+func diffHop(ttl int, before, after *Hop, rttThreshold time.Duration) HopDiff {
+	d := HopDiff{TTL: ttl, Old: []net.IP{}, New: []net.IP{}}
+	if before != nil {
+		d.Old = before.Responders
+	}
+	if after != nil {
+		d.New = after.Responders
+	}
+
+	switch {
+	case before == nil:
+		if len(d.New) > 0 {
+			d.Change = HopAdded
+		}
+		return d
+	case after == nil:
+		if len(d.Old) > 0 {
+			d.Change = HopRemoved
+		}
+		return d
+	case len(d.Old) > 0 && len(d.New) == 0:
+		d.Change = HopUnresponsive
+		return d
+	case len(d.Old) == 0 && len(d.New) > 0:
+		d.Change = HopResponsive
+		return d
+	}
+
+	for _, ip := range d.New {
+		if !containsIP(d.Old, ip) {
+			d.Added = append(d.Added, ip)
+		}
+	}
+	for _, ip := range d.Old {
+		if !containsIP(d.New, ip) {
+			d.Removed = append(d.Removed, ip)
+		}
+	}
+	if len(d.Added) > 0 || len(d.Removed) > 0 {
+		d.Change = HopChanged
+	}
+
+	if before.Received > 0 && after.Received > 0 {
+		d.OldRTT, d.NewRTT = before.RTTAvg, after.RTTAvg
+		change := d.NewRTT - d.OldRTT
+		d.RTTChanged = change > rttThreshold || -change > rttThreshold
+	}
+	return d
+}
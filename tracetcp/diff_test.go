@@ -0,0 +1,50 @@
+package tracetcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/0xcafed00d/assert"
+)
+
+func resultFromEvents(events []TraceEvent) *Result {
+	r := NewResult(testOptions())
+	for _, e := range events {
+		r.Add(e)
+	}
+	return r
+}
+
+func TestDiffResults(t *testing.T) {
+	assert := assert.Make(t)
+
+	before := resultFromEvents(testTraceEvents())
+	d := DiffResults(before, resultFromEvents(testTraceEvents()), DefaultRTTThreshold)
+	assert(d.PathChanged, d.OutcomeChanged, len(d.Hops)).Equal(false, false, 0)
+
+	r5 := net.IPAddr{IP: net.IPv4(10, 0, 0, 5).To4()}
+	r7 := net.IPAddr{IP: net.IPv4(10, 0, 0, 7).To4()}
+	afterEvents := []TraceEvent{
+		{Type: TraceStarted, Addr: testTraceEvents()[0].Addr},
+		{Type: TTLExpired, Hop: 1, Query: 0, Addr: testTraceEvents()[1].Addr, Time: 40 * time.Millisecond},
+		{Type: TTLExpired, Hop: 1, Query: 1, Addr: r5, Time: 40 * time.Millisecond},
+		{Type: TTLExpired, Hop: 2, Query: 0, Addr: r7, Time: 2 * time.Millisecond},
+		{Type: TimedOut, Hop: 3, Query: 0, Time: time.Second},
+		{Type: TimedOut, Hop: 4, Query: 0, Time: time.Second},
+		{Type: TraceComplete, Time: 3 * time.Second},
+	}
+	after := resultFromEvents(afterEvents)
+
+	d = DiffResults(before, after, DefaultRTTThreshold)
+	assert(d.PathChanged, d.OutcomeChanged, d.OldStatus, d.NewStatus).Equal(true, true, Connected, TimedOut)
+	assert(len(d.Hops)).Equal(3)
+	assert(d.Hops[0].String()).Equal("hop 1: changed -10.0.0.2 +10.0.0.5, rtt 2.0 ms -> 40.0 ms")
+	assert(d.Hops[1].String()).Equal("hop 2: now answered by 10.0.0.7")
+	assert(d.Hops[2].String()).Equal("hop 3: unresponsive, was 10.0.0.9")
+
+	// the trace going further with nothing answering is not a change of path
+	shorter := resultFromEvents(append(afterEvents[:5:5], TraceEvent{Type: TraceComplete}))
+	d = DiffResults(shorter, after, DefaultRTTThreshold)
+	assert(d.PathChanged).IsFalse()
+}
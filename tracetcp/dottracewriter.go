@@ -0,0 +1,55 @@
+package tracetcp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DOTTraceWriter writes the traced path as a Graphviz digraph.
+type DOTTraceWriter struct {
+	resultWriter
+	asn func(ip net.IPAddr) (string, error)
+}
+
+func (w *DOTTraceWriter) Init(config OutputConfig) error {
+	w.render = w.writeDOT
+	if w.asn == nil && config.ASNLookups {
+		w.asn = LookupASN
+	}
+	return w.resultWriter.Init(config)
+}
+
+func (w *DOTTraceWriter) writeDOT(r *Result) error {
+	t := buildTopology(r, w.asn)
+	out := bufio.NewWriter(w.config.Out)
+
+	fmt.Fprintf(out, "digraph %v {\n", dotQuote(fmt.Sprintf("tracetcp %v:%v", r.Target, r.Port)))
+	fmt.Fprintf(out, "\trankdir=LR;\n")
+	fmt.Fprintf(out, "\tnode [shape=box];\n")
+	for _, n := range t.Nodes {
+		attrs := []string{"label=" + dotQuote(strings.Join(n.labelLines(), "\n"))}
+		switch n.Kind {
+		case topologyLocal:
+			attrs = append(attrs, "shape=ellipse")
+		case topologyAnonymous:
+			attrs = append(attrs, "style=dashed")
+		case topologyDestination:
+			attrs = append(attrs, "shape=doubleoctagon")
+			if !n.Reached {
+				attrs = append(attrs, "style=dashed")
+			}
+		}
+		fmt.Fprintf(out, "\t%v [%v];\n", dotQuote(n.ID), strings.Join(attrs, ", "))
+	}
+	for _, e := range t.Edges {
+		if e.Inferred {
+			fmt.Fprintf(out, "\t%v -> %v [style=dashed];\n", dotQuote(e.From), dotQuote(e.To))
+		} else {
+			fmt.Fprintf(out, "\t%v -> %v;\n", dotQuote(e.From), dotQuote(e.To))
+		}
+	}
+	fmt.Fprintf(out, "}\n")
+	return out.Flush()
+}
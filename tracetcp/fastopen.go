@@ -0,0 +1,137 @@
+package tracetcp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+type FastOpenStatus int
+
+const (
+	FastOpenNotTested FastOpenStatus = iota
+	FastOpenWorking
+	FastOpenNoCookie
+	FastOpenDataIgnored
+	FastOpenFailed
+)
+
+// implementation of fmt.Stinger interface
+func (s FastOpenStatus) String() string {
+	switch s {
+	case FastOpenNotTested:
+		return "not tested"
+	case FastOpenWorking:
+		return "working"
+	case FastOpenNoCookie:
+		return "no cookie returned"
+	case FastOpenDataIgnored:
+		return "SYN data not accepted"
+	case FastOpenFailed:
+		return "handshake failed"
+	}
+	return "Invalid FastOpenStatus"
+}
+
+// implementation of encoding.TextMarshaler interface
+func (s FastOpenStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// implementation of encoding.TextUnmarshaler interface
+func (s *FastOpenStatus) UnmarshalText(text []byte) error {
+	for v := FastOpenNotTested; v <= FastOpenFailed; v++ {
+		if v.String() == string(text) {
+			*s = v
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid FastOpenStatus: %s", text)
+}
+
+type FastOpenResult struct {
+	Status FastOpenStatus
+	Cookie []byte
+
+	// bytes of SYN data acknowledged by the SYN-ACK
+	DataAcked int
+
+	// where the cookie request was stripped from the probes, if a router
+	// quoting the full header showed it
+	Stripped *HopOptionChange
+}
+
+// implementation of fmt.Stinger interface
+func (r FastOpenResult) String() string {
+	switch {
+	case r.Status == FastOpenWorking:
+		return fmt.Sprintf("working, cookie %s, %d bytes of SYN data accepted", hex.EncodeToString(r.Cookie), r.DataAcked)
+	case r.Stripped != nil:
+		return fmt.Sprintf("%v, option stripped between hop %d and hop %d (%v)",
+			r.Status, r.Stripped.PrevHop, r.Stripped.Hop, r.Stripped.Responder.String())
+	}
+	return r.Status.String()
+}
+
+// sent with the cookie on the second SYN
+var fastOpenPayload = []byte("HEAD / HTTP/1.0\r\n\r\n")
+
+// sends the probes of tryFastOpen, replaced in tests
+var sendSYN = trySYN
+
+// tryFastOpen requests a fast open cookie from the destination and then
+// sends a second SYN carrying the cookie and data, checking whether the data
+// is acknowledged.
+func tryFastOpen(dest net.IPAddr, port, ttl int, timeout time.Duration, source net.IPAddr, opts TCPOptions,
+	icmpChan chan icmpEvent, tcpChan chan tcpEvent) (result FastOpenResult) {
+
+	if len(opts) == 0 {
+		opts = DefaultTCPOptions
+	}
+
+	ev, _, tcpev := sendSYN(dest, port, ttl, 0, timeout, source, opts.With(TCPOption{Kind: TCPOptFastOpen}), nil, icmpChan, tcpChan)
+	log.Println("fast open cookie request", ev, tcpev)
+	if ev.evtype != connectConnected {
+		result.Status = FastOpenFailed
+		return
+	}
+
+	cookie, ok := tcpev.options.Find(TCPOptFastOpen)
+	if !ok || len(cookie.Data) == 0 {
+		result.Status = FastOpenNoCookie
+		return
+	}
+	result.Cookie = cookie.Data
+
+	ev, _, tcpev = sendSYN(dest, port, ttl, 1, timeout, source, opts.With(cookie), fastOpenPayload, icmpChan, tcpChan)
+	log.Println("fast open with cookie", ev, tcpev)
+	if ev.evtype != connectConnected {
+		result.Status = FastOpenFailed
+		return
+	}
+
+	result.DataAcked = int(tcpev.tcp.AckNum - ev.sequence - 1)
+	if result.DataAcked == len(fastOpenPayload) {
+		result.Status = FastOpenWorking
+	} else {
+		result.Status = FastOpenDataIgnored
+	}
+	return
+}
+
+// locateStripping finds the hop at which the cookie request was removed from
+// the probes leading up to the destination.
+func (r *FastOpenResult) locateStripping(hopEvents []TraceEvent) {
+	if r.Status != FastOpenNoCookie {
+		return
+	}
+	for _, c := range FindOptionChanges(hopEvents) {
+		if c.Type == OptionStripped && c.Sent.Kind == TCPOptFastOpen {
+			c := c
+			r.Stripped = &c
+			return
+		}
+	}
+}
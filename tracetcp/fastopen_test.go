@@ -0,0 +1,96 @@
+package tracetcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/0xcafed00d/assert"
+)
+
+// fakeSYN replaces sendSYN with one answering each probe in turn with the
+// given events, each SYN-ACK carrying the event's options and acknowledging
+// any SYN data if ackData is set. it returns the options of each probe sent.
+func fakeSYN(ackData bool, replies ...connectEvent) *[]TCPOptions {
+	var sent []TCPOptions
+	sendSYN = func(dest net.IPAddr, port, ttl, query int, timeout time.Duration, source net.IPAddr, opts TCPOptions, payload []byte,
+		icmpChan chan icmpEvent, tcpChan chan tcpEvent) (connectEvent, icmpEvent, tcpEvent) {
+		sent = append(sent, opts)
+		ev := replies[query]
+		ev.sequence = uint32(1000 * (query + 1))
+		tcpev := tcpEvent{tcp: TCPHeader{Flags: tcpFlagSYN | tcpFlagACK, AckNum: ev.sequence + 1}, options: ev.sentOptions}
+		if ackData {
+			tcpev.tcp.AckNum += uint32(len(payload))
+		}
+		return ev, icmpEvent{}, tcpev
+	}
+	return &sent
+}
+
+func TestTryFastOpen(t *testing.T) {
+	assert := assert.Make(t)
+	defer func() { sendSYN = trySYN }()
+
+	dest := net.IPAddr{IP: net.IPv4(192, 0, 2, 1)}
+	cookie := TCPOption{Kind: TCPOptFastOpen, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	granted := connectEvent{evtype: connectConnected, sentOptions: TCPOptions{cookie}}
+	connected := connectEvent{evtype: connectConnected}
+	try := func() FastOpenResult {
+		return tryFastOpen(dest, 443, 64, time.Second, net.IPAddr{}, nil, nil, nil)
+	}
+
+	sent := fakeSYN(true, granted, connected)
+	r := try()
+	assert(r.Status, r.Cookie, r.DataAcked).Equal(FastOpenWorking, cookie.Data, len(fastOpenPayload))
+	assert(r.String()).Equal("working, cookie 0102030405060708, 19 bytes of SYN data accepted")
+	assert(len(*sent)).Equal(2)
+	assert((*sent)[0].String()).Equal("mss=1460,sack,ts,nop,wscale=7,tfo")
+	assert((*sent)[1].String()).Equal("mss=1460,sack,ts,nop,wscale=7,tfo=0102030405060708")
+
+	// the server fell back to a normal handshake, acknowledging only the SYN
+	fakeSYN(false, granted, connected)
+	r = try()
+	assert(r.Status, r.DataAcked).Equal(FastOpenDataIgnored, 0)
+	assert(r.String()).Equal("SYN data not accepted")
+
+	sent = fakeSYN(true, connected)
+	r = try()
+	assert(r.Status, r.String()).Equal(FastOpenNoCookie, "no cookie returned")
+	assert(len(*sent)).Equal(1)
+
+	fakeSYN(true, connectEvent{evtype: connectTimedOut})
+	r = try()
+	assert(r.Status).Equal(FastOpenFailed)
+
+	fakeSYN(true, granted, connectEvent{evtype: connectRefused})
+	r = try()
+	assert(r.Status, r.Cookie).Equal(FastOpenFailed, cookie.Data)
+}
+
+func TestLocateStripping(t *testing.T) {
+	assert := assert.Make(t)
+
+	sent, _ := ParseTCPOptionSpec("mss=1460,sack,tfo")
+	hop := func(n int, spec string) TraceEvent {
+		seen, _ := ParseTCPOptionSpec(spec)
+		return TraceEvent{Type: TTLExpired, Hop: n, Addr: net.IPAddr{IP: net.IPv4(10, 0, 0, byte(n))},
+			SentOptions: sent, ReplyOptions: seen, ReplyOptionsSeen: true}
+	}
+	events := []TraceEvent{hop(1, "mss=1460,sack,tfo"), {Type: TimedOut, Hop: 2}, hop(3, "mss=1460,sack"), hop(4, "mss=1400,sack")}
+
+	r := FastOpenResult{Status: FastOpenNoCookie}
+	r.locateStripping(events)
+	assert(r.Stripped != nil).IsTrue()
+	assert(r.Stripped.Hop, r.Stripped.PrevHop).Equal(3, 1)
+	assert(r.String()).Equal("no cookie returned, option stripped between hop 1 and hop 3 (10.0.0.3)")
+
+	// the cookie was granted, so the option going missing did not matter
+	r = FastOpenResult{Status: FastOpenWorking}
+	r.locateStripping(events)
+	assert(r.Stripped == nil).IsTrue()
+
+	// nothing along the path quoted the options back
+	r = FastOpenResult{Status: FastOpenNoCookie}
+	r.locateStripping([]TraceEvent{{Type: TTLExpired, Hop: 1, SentOptions: sent}})
+	assert(r.Stripped == nil).IsTrue()
+}
@@ -0,0 +1,367 @@
+package tracetcp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// StackFingerprint describes the SYN-ACK or RST returned by the destination,
+// in the style of p0f v3. Signature is the observation written in p0f's
+// signature syntax, so unknown stacks can be added to a signature file.
+type StackFingerprint struct {
+	InitialTTL  int
+	MSS         int
+	Window      int
+	WindowScale int
+	Layout      string
+	Quirks      []string
+	Signature   string
+
+	// the label of the matching signature, empty if none matched
+	Label string
+}
+
+// implementation of fmt.Stinger interface
+func (f StackFingerprint) String() string {
+	if f.Label == "" {
+		return fmt.Sprintf("unknown (sig %v)", f.Signature)
+	}
+	return fmt.Sprintf("%v (sig %v)", f.Label, f.Signature)
+}
+
+func fingerprintReply(tcpev tcpEvent) StackFingerprint {
+	fp := StackFingerprint{
+		InitialTTL:  InferInitialTTL(int(tcpev.ip.TTL)),
+		MSS:         -1,
+		Window:      int(tcpev.tcp.Window),
+		WindowScale: -1,
+		Layout:      optionLayout(tcpev.rawOptions),
+		Quirks:      replyQuirks(tcpev),
+	}
+	if mss, ok := tcpev.options.MSS(); ok {
+		fp.MSS = mss
+	}
+	if ws, ok := tcpev.options.Find(TCPOptWindowScale); ok && len(ws.Data) == 1 {
+		fp.WindowScale = int(ws.Data[0])
+	}
+
+	mss, scale := "*", "0"
+	if fp.MSS >= 0 {
+		mss = strconv.Itoa(fp.MSS)
+	}
+	if fp.WindowScale >= 0 {
+		scale = strconv.Itoa(fp.WindowScale)
+	}
+	fp.Signature = fmt.Sprintf("4:%d:0:%v:%d,%v:%v:%v:0",
+		fp.InitialTTL, mss, fp.Window, scale, fp.Layout, strings.Join(fp.Quirks, ","))
+	return fp
+}
+
+// optionLayout writes the options in p0f olayout form, e.g. "mss,sok,ts,nop,ws"
+func optionLayout(raw []byte) string {
+	var layout []string
+	for len(raw) > 0 {
+		kind := TCPOptionKind(raw[0])
+		switch kind {
+		case TCPOptEnd:
+			layout = append(layout, fmt.Sprintf("eol+%d", len(raw)-1))
+			raw = nil
+			continue
+		case TCPOptNOP:
+			layout = append(layout, "nop")
+			raw = raw[1:]
+			continue
+		case TCPOptMSS:
+			layout = append(layout, "mss")
+		case TCPOptWindowScale:
+			layout = append(layout, "ws")
+		case TCPOptSACKPermitted:
+			layout = append(layout, "sok")
+		case TCPOptSACK:
+			layout = append(layout, "sack")
+		case TCPOptTimestamps:
+			layout = append(layout, "ts")
+		default:
+			layout = append(layout, fmt.Sprintf("?%d", byte(kind)))
+		}
+		if len(raw) < 2 || int(raw[1]) < 2 || int(raw[1]) > len(raw) {
+			break
+		}
+		raw = raw[raw[1]:]
+	}
+	return strings.Join(layout, ",")
+}
+
+func replyQuirks(tcpev tcpEvent) []string {
+	var quirks []string
+	ip, tcp := tcpev.ip, tcpev.tcp
+
+	df := ip.FlagsFragmentOff&0x4000 != 0
+	if df {
+		quirks = append(quirks, "df")
+		if ip.ID != 0 {
+			quirks = append(quirks, "id+")
+		}
+	} else if ip.ID == 0 {
+		quirks = append(quirks, "id-")
+	}
+	if ip.TOS&0x3 != 0 || tcp.Flags&(tcpFlagECE|tcpFlagCWR) != 0 {
+		quirks = append(quirks, "ecn")
+	}
+	if ip.FlagsFragmentOff&0x8000 != 0 {
+		quirks = append(quirks, "0+")
+	}
+	if tcp.Sequence == 0 {
+		quirks = append(quirks, "seq-")
+	}
+	if tcp.Flags&tcpFlagACK != 0 && tcp.AckNum == 0 {
+		quirks = append(quirks, "ack-")
+	}
+	if tcp.Flags&tcpFlagACK == 0 && tcp.AckNum != 0 {
+		quirks = append(quirks, "ack+")
+	}
+	if tcp.Flags&tcpFlagURG != 0 {
+		quirks = append(quirks, "urgf+")
+	} else if tcp.Urgent != 0 {
+		quirks = append(quirks, "uptr+")
+	}
+	if tcp.Flags&tcpFlagPSH != 0 {
+		quirks = append(quirks, "pushf+")
+	}
+	if ts, ok := tcpev.options.Find(TCPOptTimestamps); ok && len(ts.Data) == 8 && binary.BigEndian.Uint32(ts.Data) == 0 {
+		quirks = append(quirks, "ts1-")
+	}
+	for _, b := range tcpev.rawOptions[len(tcpev.rawOptions)-paddingLen(tcpev.rawOptions):] {
+		if b != 0 {
+			quirks = append(quirks, "opt+")
+			break
+		}
+	}
+	if ws, ok := tcpev.options.Find(TCPOptWindowScale); ok && len(ws.Data) == 1 && ws.Data[0] > 14 {
+		quirks = append(quirks, "exws")
+	}
+	if tcpev.badOptions {
+		quirks = append(quirks, "bad")
+	}
+	return quirks
+}
+
+// paddingLen returns the number of bytes following an EOL option.
+func paddingLen(raw []byte) int {
+	for i := 0; i < len(raw); {
+		switch TCPOptionKind(raw[i]) {
+		case TCPOptEnd:
+			return len(raw) - i - 1
+		case TCPOptNOP:
+			i++
+		default:
+			if i+1 >= len(raw) || raw[i+1] < 2 {
+				return 0
+			}
+			i += int(raw[i+1])
+		}
+	}
+	return 0
+}
+
+type fingerprintSig struct {
+	label  string
+	ittl   int
+	mss    int
+	wsize  string
+	scale  int
+	layout string
+	quirks string
+}
+
+// FingerprintDB holds SYN-ACK signatures in p0f v3 "[tcp:response]" syntax:
+//
+//	label = s:unix:Linux:3.x
+//	sig   = ver:ittl:olen:mss:wsize,scale:olayout:quirks:pclass
+//
+// a "*" matches any value, including the whole olayout and quirks fields, and
+// wsize may be given as mss*N, mtu*N or %N.
+type FingerprintDB struct {
+	sigs []fingerprintSig
+}
+
+// ParseFingerprintDB reads signatures in p0f format. only the [tcp:response]
+// section is used, other sections are skipped.
+func ParseFingerprintDB(r io.Reader) (*FingerprintDB, error) {
+	db := &FingerprintDB{}
+	scanner := bufio.NewScanner(r)
+	section, label, lineNo := "tcp:response", "", 0
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section = line[1 : len(line)-1]
+			continue
+		}
+		if section != "tcp:response" {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("fingerprints line %d: expected name = value", lineNo)
+		}
+		name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		switch name {
+		case "label":
+			label = value
+		case "sig":
+			if label == "" {
+				return nil, fmt.Errorf("fingerprints line %d: sig before label", lineNo)
+			}
+			sig, err := parseFingerprintSig(value)
+			if err != nil {
+				return nil, fmt.Errorf("fingerprints line %d: %v", lineNo, err)
+			}
+			sig.label = label
+			db.sigs = append(db.sigs, sig)
+		case "sys":
+		default:
+			return nil, fmt.Errorf("fingerprints line %d: unknown field %v", lineNo, name)
+		}
+	}
+	return db, scanner.Err()
+}
+
+func parseFingerprintSig(sig string) (s fingerprintSig, err error) {
+	fields := strings.Split(sig, ":")
+	if len(fields) != 8 {
+		return s, fmt.Errorf("signature needs 8 fields: %v", sig)
+	}
+
+	parseWild := func(v string) (int, error) {
+		if v == "*" {
+			return -1, nil
+		}
+		return strconv.Atoi(strings.TrimSuffix(v, "-"))
+	}
+
+	if s.ittl, err = parseWild(fields[1]); err != nil {
+		return s, fmt.Errorf("invalid ittl: %v", fields[1])
+	}
+	if s.mss, err = parseWild(fields[3]); err != nil {
+		return s, fmt.Errorf("invalid mss: %v", fields[3])
+	}
+
+	win := strings.SplitN(fields[4], ",", 2)
+	if len(win) != 2 {
+		return s, fmt.Errorf("invalid window: %v", fields[4])
+	}
+	s.wsize = win[0]
+	if s.scale, err = parseWild(win[1]); err != nil {
+		return s, fmt.Errorf("invalid scale: %v", win[1])
+	}
+
+	s.layout = fields[5]
+	s.quirks = normaliseQuirks(fields[6])
+	return s, nil
+}
+
+func normaliseQuirks(q string) string {
+	if q == "" {
+		return ""
+	}
+	quirks := strings.Split(q, ",")
+	sort.Strings(quirks)
+	return strings.Join(quirks, ",")
+}
+
+func (s fingerprintSig) matches(fp StackFingerprint) bool {
+	if s.ittl != -1 && s.ittl != fp.InitialTTL {
+		return false
+	}
+	if s.mss != -1 && s.mss != fp.MSS {
+		return false
+	}
+	scale := fp.WindowScale
+	if scale < 0 {
+		scale = 0
+	}
+	if s.scale != -1 && s.scale != scale {
+		return false
+	}
+	if s.layout != "*" && s.layout != fp.Layout {
+		return false
+	}
+	if s.quirks != "*" && s.quirks != normaliseQuirks(strings.Join(fp.Quirks, ",")) {
+		return false
+	}
+	return windowMatches(s.wsize, fp.Window, fp.MSS)
+}
+
+func windowMatches(spec string, window, mss int) bool {
+	multiple := func(prefix string, unit int) (bool, bool) {
+		if !strings.HasPrefix(spec, prefix) {
+			return false, false
+		}
+		n, err := strconv.Atoi(spec[len(prefix):])
+		return err == nil && unit > 0 && window == unit*n, true
+	}
+
+	if spec == "*" {
+		return true
+	}
+	if ok, isMultiple := multiple("mss*", mss); isMultiple {
+		return ok
+	}
+	if ok, isMultiple := multiple("mtu*", mss+40); isMultiple {
+		return ok
+	}
+	if strings.HasPrefix(spec, "%") {
+		n, err := strconv.Atoi(spec[1:])
+		return err == nil && n > 0 && window%n == 0
+	}
+	n, err := strconv.Atoi(spec)
+	return err == nil && n == window
+}
+
+// Match returns the label of the first signature matching fp.
+func (db *FingerprintDB) Match(fp StackFingerprint) (string, bool) {
+	for _, s := range db.sigs {
+		if s.matches(fp) {
+			return s.label, true
+		}
+	}
+	return "", false
+}
+
+// Extend adds the signatures in other ahead of those already in db, so they
+// take precedence.
+func (db *FingerprintDB) Extend(other *FingerprintDB) {
+	db.sigs = append(append([]fingerprintSig{}, other.sigs...), db.sigs...)
+}
+
+// LoadFingerprintDB returns the bundled signatures extended with those in the
+// named file.
+func LoadFingerprintDB(path string) (*FingerprintDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	extra, err := ParseFingerprintDB(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	db := &FingerprintDB{sigs: append([]fingerprintSig{}, DefaultFingerprints.sigs...)}
+	db.Extend(extra)
+	return db, nil
+}
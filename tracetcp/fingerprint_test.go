@@ -0,0 +1,60 @@
+package tracetcp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/0xcafed00d/assert"
+)
+
+func TestFingerprintReply(t *testing.T) {
+	assert := assert.Make(t)
+
+	raw := []byte{2, 4, 0x05, 0xb4, 4, 2, 8, 10, 0, 0, 0, 1, 0, 0, 0, 2, 1, 3, 3, 7}
+	opts, _ := DecodeTCPOptions(raw)
+	ev := tcpEvent{
+		ip:         IPHeader{TTL: 57, FlagsFragmentOff: 0x4000},
+		tcp:        TCPHeader{Sequence: 1, AckNum: 2, Flags: tcpFlagSYN | tcpFlagACK, Window: 65160},
+		options:    opts,
+		rawOptions: raw,
+	}
+
+	fp := fingerprintReply(ev)
+	assert(fp.Signature).Equal("4:64:0:1460:65160,7:mss,sok,ts,nop,ws:df:0")
+	assert(DefaultFingerprints.Match(fp)).Equal("s:unix:Linux:3.x+", true)
+
+	ev.ip = IPHeader{TTL: 250, ID: 1234}
+	ev.rawOptions = []byte{2, 4, 0x05, 0xb4, 0, 0, 0, 0}
+	ev.options, _ = DecodeTCPOptions(ev.rawOptions)
+	fp = fingerprintReply(ev)
+	assert(fp.Signature).Equal("4:255:0:1460:65160,0:mss,eol+3::0")
+	assert(DefaultFingerprints.Match(fp)).Equal("g:!:Network device or load balancer:ittl 255", true)
+}
+
+func TestParseFingerprintDB(t *testing.T) {
+	assert := assert.Make(t)
+
+	db, err := ParseFingerprintDB(strings.NewReader(`
+; local additions
+[tcp:request]
+label = s:unix:ignored:1
+sig   = bogus
+
+[tcp:response]
+label = s:!:Example LB:1.0
+sig   = *:64:0:1400:mss*10,2:mss,ws:df,id+:0
+`))
+	assert(err).NoError()
+
+	fp := StackFingerprint{InitialTTL: 64, MSS: 1400, Window: 14000, WindowScale: 2, Layout: "mss,ws", Quirks: []string{"id+", "df"}}
+	assert(db.Match(fp)).Equal("s:!:Example LB:1.0", true)
+
+	fp.Window = 14001
+	_, ok := db.Match(fp)
+	assert(ok).Equal(false)
+
+	_, err = ParseFingerprintDB(strings.NewReader("sig = *:64:0:*:*,*:mss:df:0"))
+	assert(err).HasError()
+	_, err = ParseFingerprintDB(strings.NewReader("label = x\nsig = *:64:0"))
+	assert(err).HasError()
+}
@@ -0,0 +1,49 @@
+package tracetcp
+
+import (
+	"strings"
+)
+
+// signatures for SYN-ACK replies, in p0f v3 syntax. more specific signatures
+// come first as the first match wins. add local signatures with -S.
+const bundledFingerprints = `
+[tcp:response]
+
+label = s:unix:Linux:3.x+
+sig   = *:64:0:*:*,*:mss,sok,ts,nop,ws:df:0
+sig   = *:64:0:*:*,*:mss,nop,nop,sok,nop,ws:df:0
+sig   = *:64:0:*:*,*:mss,nop,nop,ts,nop,ws:df:0
+sig   = *:64:0:*:*,0:mss,sok,ts:df:0
+sig   = *:64:0:*:*,0:mss,nop,nop,sok:df:0
+sig   = *:64:0:*:*,0:mss:df:0
+
+label = s:unix:FreeBSD:9.x+
+sig   = *:64:0:*:65535,*:mss,nop,ws,sok,ts:df,id+:0
+sig   = *:64:0:*:65535,*:mss,nop,ws,sok,eol+1:df,id+:0
+
+label = s:unix:Mac OS X:10.x+
+sig   = *:64:0:*:65535,*:mss,nop,ws,nop,nop,ts,sok,eol+1:df,id+:0
+sig   = *:64:0:*:65535,*:mss,nop,ws,sok,eol+1:df,id+:0
+
+label = s:win:Windows:7+
+sig   = *:128:0:*:*,*:mss,nop,ws,sok,ts:df,id+:0
+sig   = *:128:0:*:*,*:mss,nop,ws,nop,nop,sok:df,id+:0
+sig   = *:128:0:*:*,0:mss,nop,nop,sok:df,id+:0
+
+label = s:win:Windows:XP
+sig   = *:128:0:*:65535,0:mss:df,id+:0
+sig   = *:128:0:*:65535,0:mss,nop,nop,sok:df,id+:0
+
+label = g:!:Network device or load balancer:ittl 255
+sig   = *:255:0:*:*,*:*:*:0
+`
+
+var DefaultFingerprints *FingerprintDB
+
+func init() {
+	var err error
+	DefaultFingerprints, err = ParseFingerprintDB(strings.NewReader(bundledFingerprints))
+	if err != nil {
+		panic(err)
+	}
+}
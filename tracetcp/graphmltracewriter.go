@@ -0,0 +1,120 @@
+package tracetcp
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GraphMLTraceWriter writes the traced path as a GraphML document.
+type GraphMLTraceWriter struct {
+	resultWriter
+	asn func(ip net.IPAddr) (string, error)
+}
+
+type graphmlKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphmlData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphmlNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphmlData `xml:"data"`
+}
+
+type graphmlEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphmlData `xml:"data"`
+}
+
+type graphmlDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphmlKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphmlNode `xml:"node"`
+		Edges       []graphmlEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+var graphmlKeys = []graphmlKey{
+	{ID: "label", For: "node", Name: "label", Type: "string"},
+	{ID: "kind", For: "node", Name: "kind", Type: "string"},
+	{ID: "ttl", For: "node", Name: "ttl", Type: "int"},
+	{ID: "addr", For: "node", Name: "addr", Type: "string"},
+	{ID: "name", For: "node", Name: "name", Type: "string"},
+	{ID: "asn", For: "node", Name: "asn", Type: "string"},
+	{ID: "rtt_ms", For: "node", Name: "rtt_ms", Type: "double"},
+	{ID: "reached", For: "node", Name: "reached", Type: "boolean"},
+	{ID: "inferred", For: "edge", Name: "inferred", Type: "boolean"},
+}
+
+func (w *GraphMLTraceWriter) Init(config OutputConfig) error {
+	w.render = w.writeGraphML
+	if w.asn == nil && config.ASNLookups {
+		w.asn = LookupASN
+	}
+	return w.resultWriter.Init(config)
+}
+
+func (w *GraphMLTraceWriter) writeGraphML(r *Result) error {
+	t := buildTopology(r, w.asn)
+
+	doc := graphmlDocument{XMLNS: "http://graphml.graphdrawing.org/xmlns", Keys: graphmlKeys}
+	doc.Graph.ID = fmt.Sprintf("tracetcp %v:%v", r.Target, r.Port)
+	doc.Graph.EdgeDefault = "directed"
+
+	for _, n := range t.Nodes {
+		node := graphmlNode{ID: n.ID}
+		add := func(key, value string) {
+			if value != "" {
+				node.Data = append(node.Data, graphmlData{Key: key, Value: value})
+			}
+		}
+		add("label", strings.Join(n.labelLines(), "\n"))
+		add("kind", string(n.Kind))
+		if n.TTL > 0 {
+			add("ttl", strconv.Itoa(n.TTL))
+		}
+		if n.Addr != nil {
+			add("addr", n.Addr.String())
+		}
+		add("name", n.Name)
+		add("asn", n.ASN)
+		if n.RTT > 0 {
+			add("rtt_ms", strconv.FormatFloat(float64(n.RTT)/float64(time.Millisecond), 'f', 3, 64))
+		}
+		add("reached", strconv.FormatBool(n.Reached))
+		doc.Graph.Nodes = append(doc.Graph.Nodes, node)
+	}
+	for _, e := range t.Edges {
+		edge := graphmlEdge{Source: e.From, Target: e.To}
+		if e.Inferred {
+			edge.Data = append(edge.Data, graphmlData{Key: "inferred", Value: "true"})
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, edge)
+	}
+
+	io.WriteString(w.config.Out, xml.Header)
+	enc := xml.NewEncoder(w.config.Out)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.config.Out, "\n")
+	return err
+}
@@ -0,0 +1,100 @@
+package tracetcp
+
+import (
+	"fmt"
+	"html/template"
+	"time"
+)
+
+// HTMLTraceWriter writes the trace as a standalone HTML document with the
+// facts about the trace, a table of the hops with RTTs coloured by latency,
+// and the outcome of every probe in a collapsible row under each hop.
+type HTMLTraceWriter struct {
+	resultWriter
+}
+
+func (w *HTMLTraceWriter) Init(config OutputConfig) error {
+	w.render = w.writeHTML
+	return w.resultWriter.Init(config)
+}
+
+// latencyClass returns the CSS class an RTT is coloured with.
+func latencyClass(d time.Duration) string {
+	switch {
+	case d < 50*time.Millisecond:
+		return "fast"
+	case d < 150*time.Millisecond:
+		return "medium"
+	}
+	return "slow"
+}
+
+var htmlReport = template.Must(template.New("html").Funcs(template.FuncMap{
+	"ms": func(d time.Duration) string {
+		return fmt.Sprintf("%.1f ms", millis(d))
+	},
+	"percent": func(f float64) string {
+		return fmt.Sprintf("%.0f%%", f*100)
+	},
+	"latency": latencyClass,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>tracetcp to {{.Result.Target}} port {{.Result.Port}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; color: #222; margin: 2em; }
+h1 { font-size: 18px; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 10px; text-align: left; vertical-align: top; }
+table.facts th { color: #666; font-weight: normal; }
+table.hops th { border-bottom: 2px solid #ccc; }
+table.hops td { border-bottom: 1px solid #eee; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+.fast { color: #1a7f37; }
+.medium { color: #b35900; }
+.slow { color: #c00; font-weight: bold; }
+.lost { color: #c00; }
+details { color: #666; }
+</style>
+</head>
+<body>
+<h1>tracetcp to {{.Result.Target}} port {{.Result.Port}}</h1>
+<table class="facts">
+{{- range .Fields}}
+<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{- end}}
+</table>
+<table class="hops">
+<tr><th>Hop</th><th>Host</th><th>Loss</th><th>Best</th><th>Avg</th><th>Worst</th><th>Replies</th></tr>
+{{- range .Hops}}
+<tr>
+<td class="num">{{.TTL}}</td>
+<td>{{range $i, $h := .Hosts}}{{if $i}}<br>{{end}}{{$h}}{{else}}*{{end}}</td>
+<td class="num{{if .Hop.Loss}} lost{{end}}">{{percent .Hop.Loss}}</td>
+{{- if .Hop.Received}}
+<td class="num {{latency .Hop.RTTMin}}">{{ms .Hop.RTTMin}}</td>
+<td class="num {{latency .Hop.RTTAvg}}">{{ms .Hop.RTTAvg}}</td>
+<td class="num {{latency .Hop.RTTMax}}">{{ms .Hop.RTTMax}}</td>
+{{- else}}
+<td></td><td></td><td></td>
+{{- end}}
+<td><details><summary>{{range $i, $p := .Probes}}{{if $i}}, {{end}}<span class="{{if $p.Replied}}{{latency $p.RTT}}{{else}}lost{{end}}">{{$p.Text}}</span>{{end}}</summary>
+{{- range .Probes}}
+<div>{{.Text}}: {{.Detail}}</div>
+{{- end}}
+</details></td>
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+func (w *HTMLTraceWriter) writeHTML(r *Result) error {
+	return htmlReport.Execute(w.config.Out, struct {
+		Result *Result
+		Fields []reportField
+		Hops   []reportHop
+	}{r, reportFields(r), reportHops(r)})
+}
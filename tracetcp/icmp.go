@@ -42,6 +42,21 @@ type icmpEvent struct {
 	remoteAddr net.IPAddr
 	remotePort int
 	err        error
+
+	// the destination of the probe the reply quotes
+	probeAddr net.IPAddr
+
+	// the probe's TCP header as quoted back by the router. routers that
+	// follow RFC 792 only quote the first 8 bytes, in which case
+	// optionsQuoted is false.
+	quotedTCP     TCPHeader
+	quotedOptions TCPOptions
+	optionsQuoted bool
+
+	// remaining TTL, type and code of the ICMP reply
+	ttl      int
+	icmpType int
+	icmpCode int
 }
 
 // implementation of fmt.Stinger interface
@@ -75,6 +90,37 @@ type IPHeader struct {
 	DestIP           [4]byte
 }
 
+const (
+	icmpTypeUnreachable  = 3
+	icmpTypeTimeExceeded = 11
+)
+
+// UnreachableAnnotation returns the annotation traceroute prints for an ICMP
+// destination unreachable code, e.g. "!H" for host unreachable.
+func UnreachableAnnotation(code int) string {
+	switch code {
+	case 0, 6, 8, 11:
+		return "!N"
+	case 1, 7, 12:
+		return "!H"
+	case 2:
+		return "!P"
+	case 3:
+		return ""
+	case 4:
+		return "!F"
+	case 5:
+		return "!S"
+	case 9, 10, 13:
+		return "!X"
+	case 14:
+		return "!V"
+	case 15:
+		return "!C"
+	}
+	return fmt.Sprintf("!<%d>", code)
+}
+
 type ICMPHeader struct {
 	Type   byte
 	Code   byte
@@ -83,65 +129,162 @@ type ICMPHeader struct {
 }
 
 type TCPHeader struct {
-	SrcPort  uint16
-	DestPort uint16
-	Sequence uint32
+	SrcPort    uint16
+	DestPort   uint16
+	Sequence   uint32
+	AckNum     uint32
+	DataOffset byte
+	Flags      byte
+	Window     uint16
+	Checksum   uint16
+	Urgent     uint16
 }
 
-func receiveICMP(result chan icmpEvent) {
+const (
+	tcpFlagFIN = 0x01
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+	tcpFlagURG = 0x20
+	tcpFlagECE = 0x40
+	tcpFlagCWR = 0x80
+)
+
+const (
+	ipHeaderLen  = 20
+	tcpHeaderLen = 20
+)
 
-	// Set up the socket to receive inbound packets
-	sock, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_ICMP)
+// decodeTCPHeader decodes as much of a TCP header as is present in data.
+// complete is false if the header or its options were truncated.
+func decodeTCPHeader(data []byte) (tcp TCPHeader, opts TCPOptions, complete bool, err error) {
+	if len(data) < 8 {
+		err = fmt.Errorf("TCP header truncated: %d bytes", len(data))
+		return
+	}
+
+	if len(data) < tcpHeaderLen {
+		tcp.SrcPort = binary.BigEndian.Uint16(data[0:])
+		tcp.DestPort = binary.BigEndian.Uint16(data[2:])
+		tcp.Sequence = binary.BigEndian.Uint32(data[4:])
+		return
+	}
+
+	err = binary.Read(bytes.NewReader(data), binary.BigEndian, &tcp)
 	if err != nil {
-		result <- makeICMPErrorEvent(&icmpEvent{}, fmt.Errorf("%v. Did you forget to run as root?", err))
 		return
 	}
 
+	hdrlen := int(tcp.DataOffset>>4) * 4
+	if hdrlen < tcpHeaderLen || hdrlen > len(data) {
+		return
+	}
+	opts, err = DecodeTCPOptions(data[tcpHeaderLen:hdrlen])
+	complete = err == nil
+	return
+}
+
+// listenICMP opens the raw socket used to receive inbound ICMP packets. it is
+// opened before any probes are sent so that no replies are missed.
+func listenICMP() (int, error) {
+	sock, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_ICMP)
+	if err != nil {
+		return -1, fmt.Errorf("%v. Did you forget to run as root?", err)
+	}
+
 	err = syscall.Bind(sock, &syscall.SockaddrInet4{})
+	if err == nil {
+		err = setReceiveTimeout(sock)
+	}
 	if err != nil {
-		result <- makeICMPErrorEvent(&icmpEvent{}, err)
-		return
+		syscall.Close(sock)
+		return -1, err
 	}
+	return sock, nil
+}
+
+// receiveICMP reads from sock until done is closed, then closes it.
+func receiveICMP(sock int, result chan icmpEvent, done chan struct{}) {
+	defer syscall.Close(sock)
 
-	var pkt = make([]byte, 1024)
+	var buf = make([]byte, 1500)
 	for {
 		event := icmpEvent{}
-		_, from, err := syscall.Recvfrom(sock, pkt, 0)
+		n, from, err := syscall.Recvfrom(sock, buf, 0)
+		if isClosed(done) {
+			return
+		}
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
 		if err != nil {
-			result <- makeICMPErrorEvent(&event, err)
+			select {
+			case result <- makeICMPErrorEvent(&event, err):
+			case <-done:
+			}
 			return
 		}
-		reader := bytes.NewReader(pkt)
+		pkt := buf[:n]
 		var ip IPHeader
 		var icmp ICMPHeader
-		var tcp TCPHeader
 
-		err = binary.Read(reader, binary.BigEndian, &ip)
-		if ip.Protocol != syscall.IPPROTO_ICMP {
-			break
+		err = binary.Read(bytes.NewReader(pkt), binary.BigEndian, &ip)
+		if err != nil || ip.Protocol != syscall.IPPROTO_ICMP {
+			continue
 		}
+		event.ttl = int(ip.TTL)
 
-		ipheaderlen := (ip.VerHdrLen & 0xf) * 4
-		reader = bytes.NewReader(pkt[ipheaderlen:])
+		ipheaderlen := int(ip.VerHdrLen&0xf) * 4
+		if ipheaderlen < ipHeaderLen || len(pkt) < ipheaderlen+8 {
+			continue
+		}
+		pkt = pkt[ipheaderlen:]
 
-		err = binary.Read(reader, binary.BigEndian, &icmp)
-		if icmp.Type != 11 || icmp.Code != 0 {
-			break
+		err = binary.Read(bytes.NewReader(pkt), binary.BigEndian, &icmp)
+		if err != nil {
+			continue
+		}
+		evtype := icmpTTLExpired
+		switch {
+		case icmp.Type == icmpTypeTimeExceeded && icmp.Code == 0:
+		case icmp.Type == icmpTypeUnreachable:
+			evtype = icmpNoRoute
+		default:
+			continue
 		}
+		event.icmpType, event.icmpCode = int(icmp.Type), int(icmp.Code)
+		pkt = pkt[8:]
 
-		err = binary.Read(reader, binary.BigEndian, &ip)
+		// the header of the probe that expired
+		err = binary.Read(bytes.NewReader(pkt), binary.BigEndian, &ip)
+		if err != nil || ip.Protocol != syscall.IPPROTO_TCP {
+			continue
+		}
 
-		if ip.Protocol != syscall.IPPROTO_TCP {
-			break
+		ipheaderlen = int(ip.VerHdrLen&0xf) * 4
+		if ipheaderlen < ipHeaderLen || len(pkt) < ipheaderlen {
+			continue
 		}
 
-		err = binary.Read(reader, binary.BigEndian, &tcp)
+		tcp, opts, complete, err := decodeTCPHeader(pkt[ipheaderlen:])
+		if err != nil {
+			continue
+		}
 
 		event.localAddr.IP = append(event.localAddr.IP, ip.SourceIP[:]...)
 		event.localPort = int(tcp.SrcPort)
+		event.probeAddr.IP = append(event.probeAddr.IP, ip.DestIP[:]...)
+		event.quotedTCP = tcp
+		event.quotedOptions = opts
+		event.optionsQuoted = complete
 
 		// fill in the remote endpoint deatils on the event struct
 		event.remoteAddr, _, _ = ToIPAddrAndPort(from)
-		result <- makeICMPEvent(&event, icmpTTLExpired)
+		select {
+		case result <- makeICMPEvent(&event, evtype):
+		case <-done:
+			return
+		}
 	}
 }
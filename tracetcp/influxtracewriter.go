@@ -0,0 +1,192 @@
+package tracetcp
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxTraceWriter writes InfluxDB line protocol: a tracetcp_probe point for
+// each probe as it completes, a tracetcp_hop point summarising each hop and a
+// tracetcp_trace point at the end. probe and hop points are timestamped with
+// the time their first probe was sent.
+type InfluxTraceWriter struct {
+	config  OutputConfig
+	out     *bufio.Writer
+	now     func() time.Time
+	target  string
+	started time.Time
+	hop     *Hop
+	hopSent time.Time
+	reached bool
+	lastHop int
+	err     error
+}
+
+func (w *InfluxTraceWriter) Init(config OutputConfig) error {
+	w.config = config
+	w.out = bufio.NewWriter(config.Out)
+	if w.now == nil {
+		w.now = time.Now
+	}
+	w.target = config.Target
+	w.hop = nil
+	w.reached = false
+	w.lastHop = 0
+	w.err = nil
+	return nil
+}
+
+func (w *InfluxTraceWriter) Close() error {
+	return w.out.Flush()
+}
+
+var (
+	influxTagEscaper    = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	influxStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// influxTags formats the tags of a point from name, value pairs. tags with
+// empty values are left out.
+func influxTags(pairs ...string) string {
+	var tags string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			tags += "," + pairs[i] + "=" + influxTagEscaper.Replace(pairs[i+1])
+		}
+	}
+	return tags
+}
+
+func influxString(s string) string {
+	return `"` + influxStringEscaper.Replace(s) + `"`
+}
+
+func influxMillis(d time.Duration) string {
+	return strconv.FormatFloat(millis(d), 'f', 3, 64)
+}
+
+func (w *InfluxTraceWriter) point(measurement, tags string, fields []string, ts time.Time) {
+	fmt.Fprintf(w.out, "%v%v %v %d\n", measurement, tags, strings.Join(fields, ","), ts.UnixNano())
+}
+
+func (w *InfluxTraceWriter) Event(e TraceEvent) error {
+	switch e.Type {
+	case TraceStarted:
+		w.started = e.Sent
+		if w.started.IsZero() {
+			w.started = w.now()
+		}
+		if w.target == "" {
+			w.target = e.Addr.String()
+		}
+
+	case TimedOut, TTLExpired, Connected, RemoteClosed, Unreachable:
+		sent := e.Sent
+		if sent.IsZero() {
+			sent = w.now()
+		}
+		if w.hop != nil && w.hop.TTL != e.Hop {
+			w.writeHop()
+		}
+		if w.hop == nil {
+			w.hop = &Hop{TTL: e.Hop}
+			w.hopSent = sent
+		}
+		w.hop.add(Probe{Query: e.Query, Outcome: e.Type, Addr: e.Addr.IP, RTT: e.Time})
+		w.lastHop = e.Hop
+
+		reached := e.Type == Connected || e.Type == RemoteClosed
+		w.reached = w.reached || reached
+
+		var responder string
+		fields := []string{}
+		if e.Type != TimedOut {
+			responder = e.Addr.String()
+			fields = append(fields, "rtt="+influxMillis(e.Time))
+		}
+		fields = append(fields,
+			fmt.Sprintf("lost=%v", e.Type == TimedOut),
+			fmt.Sprintf("reached=%v", reached),
+			fmt.Sprintf("query=%di", e.Query),
+			"outcome="+influxString(e.Type.String()))
+		if e.Type == Unreachable {
+			fields = append(fields, fmt.Sprintf("icmp_code=%di", e.ICMPCode))
+		}
+		w.point("tracetcp_probe", influxTags("target", w.target, "port", strconv.Itoa(w.config.Port),
+			"hop", strconv.Itoa(e.Hop), "responder", responder), fields, sent)
+
+	case TraceFailed:
+		w.err = e.Err
+
+	case TraceComplete:
+		if w.hop != nil {
+			w.writeHop()
+		}
+		fields := []string{
+			fmt.Sprintf("reached=%v", w.reached),
+			fmt.Sprintf("hops=%di", w.lastHop),
+			"duration=" + influxMillis(e.Time),
+		}
+		if w.err != nil {
+			fields = append(fields, "error="+influxString(w.err.Error()))
+		}
+		w.point("tracetcp_trace", influxTags("target", w.target, "port", strconv.Itoa(w.config.Port)), fields, w.started)
+	}
+	return w.out.Flush()
+}
+
+// writeHop writes the summary of the hop that has just finished: a point for
+// each responder with the RTTs of its replies, or one without a responder if
+// none replied, so each responder is a series of its own. sent, lost and loss
+// are those of the whole hop.
+func (w *InfluxTraceWriter) writeHop() {
+	h := w.hop
+	w.hop = nil
+
+	tags := func(responder string) string {
+		return influxTags("target", w.target, "port", strconv.Itoa(w.config.Port),
+			"hop", strconv.Itoa(h.TTL), "responder", responder)
+	}
+	hopFields := func(reached bool) []string {
+		return []string{
+			fmt.Sprintf("sent=%di", h.Sent),
+			fmt.Sprintf("lost=%di", h.Sent-h.Received),
+			"loss=" + strconv.FormatFloat(h.Loss, 'f', 3, 64),
+			fmt.Sprintf("reached=%v", reached),
+		}
+	}
+
+	if len(h.Responders) == 0 {
+		w.point("tracetcp_hop", tags(""), hopFields(false), w.hopSent)
+		return
+	}
+	for _, addr := range h.Responders {
+		var sum, min, max time.Duration
+		n := 0
+		reached := false
+		for _, p := range h.Probes {
+			if !p.Replied() || !p.Addr.Equal(addr) {
+				continue
+			}
+			if n == 0 || p.RTT < min {
+				min = p.RTT
+			}
+			if p.RTT > max {
+				max = p.RTT
+			}
+			sum += p.RTT
+			n++
+			reached = reached || p.Outcome == Connected || p.Outcome == RemoteClosed
+		}
+		fields := []string{
+			"rtt=" + influxMillis(sum/time.Duration(n)),
+			"rtt_min=" + influxMillis(min),
+			"rtt_max=" + influxMillis(max),
+			fmt.Sprintf("replies=%di", n),
+		}
+		w.point("tracetcp_hop", tags(addr.String()), append(fields, hopFields(reached)...), w.hopSent)
+	}
+}
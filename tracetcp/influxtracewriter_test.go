@@ -0,0 +1,42 @@
+package tracetcp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xcafed00d/assert"
+)
+
+func TestInfluxTraceWriter(t *testing.T) {
+	assert := assert.Make(t)
+
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var out bytes.Buffer
+	w := &InfluxTraceWriter{now: func() time.Time { return start }}
+	w.Init(OutputConfig{Options: testOptions(), Out: &out})
+	for _, e := range testTraceEvents() {
+		if e.Hop != 0 {
+			e.Sent = start.Add(time.Duration(e.Hop*10+e.Query) * time.Millisecond)
+		}
+		w.Event(e)
+	}
+	w.Close()
+
+	assert(strings.Split(out.String(), "\n")).Equal([]string{
+		`tracetcp_probe,target=test.example.com,port=80,hop=1,responder=10.0.0.1 rtt=1.000,lost=false,reached=false,query=0i,outcome="TTLExpired" 1577934245010000000`,
+		`tracetcp_probe,target=test.example.com,port=80,hop=1,responder=10.0.0.2 rtt=3.000,lost=false,reached=false,query=1i,outcome="TTLExpired" 1577934245011000000`,
+		`tracetcp_probe,target=test.example.com,port=80,hop=1 lost=true,reached=false,query=2i,outcome="TimedOut" 1577934245012000000`,
+		`tracetcp_hop,target=test.example.com,port=80,hop=1,responder=10.0.0.1 rtt=1.000,rtt_min=1.000,rtt_max=1.000,replies=1i,sent=3i,lost=1i,loss=0.333,reached=false 1577934245010000000`,
+		`tracetcp_hop,target=test.example.com,port=80,hop=1,responder=10.0.0.2 rtt=3.000,rtt_min=3.000,rtt_max=3.000,replies=1i,sent=3i,lost=1i,loss=0.333,reached=false 1577934245010000000`,
+		`tracetcp_probe,target=test.example.com,port=80,hop=2 lost=true,reached=false,query=0i,outcome="TimedOut" 1577934245020000000`,
+		`tracetcp_hop,target=test.example.com,port=80,hop=2 sent=1i,lost=1i,loss=1.000,reached=false 1577934245020000000`,
+		`tracetcp_probe,target=test.example.com,port=80,hop=3,responder=10.0.0.9 rtt=5.000,lost=false,reached=true,query=0i,outcome="Connected" 1577934245030000000`,
+		`tracetcp_hop,target=test.example.com,port=80,hop=3,responder=10.0.0.9 rtt=5.000,rtt_min=5.000,rtt_max=5.000,replies=1i,sent=1i,lost=0i,loss=0.000,reached=true 1577934245030000000`,
+		`tracetcp_trace,target=test.example.com,port=80 reached=true,hops=3i,duration=2000.000 1577934245000000000`,
+		``,
+	})
+
+	assert(influxTags("target", "a b,c=d", "responder", "")).Equal(`,target=a\ b\,c\=d`)
+}
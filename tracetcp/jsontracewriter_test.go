@@ -0,0 +1,193 @@
+package tracetcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xcafed00d/assert"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func testLookup(ip net.IPAddr) (string, error) {
+	switch ip.String() {
+	case "10.0.0.1":
+		return "router1.example.net", nil
+	case "10.0.0.9":
+		return "test.example.com", nil
+	}
+	return "", fmt.Errorf("no name for %v", ip)
+}
+
+// checkGolden compares output with the named file in testdata, rewriting it
+// instead when run with -update.
+func checkGolden(t *testing.T, name string, output []byte) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(path, output, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, golden) {
+		t.Errorf("%v: output does not match golden file:\n%s", name, output)
+	}
+}
+
+func fixedClock() func() {
+	clock := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	timeNow = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	return func() { timeNow = time.Now }
+}
+
+func writeJSONTrace(events []TraceEvent, complete bool) []byte {
+	var out bytes.Buffer
+	w := &JSONTraceWriter{}
+	w.lookup = testLookup
+	w.Init(OutputConfig{Options: testOptions(), Out: &out})
+	for _, e := range events {
+		if e.Type == TraceStarted {
+			e.Source = net.IPAddr{IP: net.IPv4(10, 0, 0, 100).To4()}
+		}
+		if e.Type == TraceComplete && !complete {
+			break
+		}
+		w.Event(e)
+	}
+	w.Close()
+	return out.Bytes()
+}
+
+func TestJSONTraceWriterGolden(t *testing.T) {
+	defer fixedClock()()
+
+	checkGolden(t, "connected.json", writeJSONTrace(testTraceEvents(), true))
+	checkGolden(t, "incomplete.json", writeJSONTrace(testTraceEvents()[:4], false))
+
+	failed := []TraceEvent{
+		testTraceEvents()[0],
+		{Type: TraceFailed, Err: fmt.Errorf("network is unreachable"), Time: time.Millisecond},
+		{Type: TraceComplete, Time: time.Millisecond},
+	}
+	checkGolden(t, "failed.json", writeJSONTrace(failed, true))
+}
+
+func TestJSONTraceWriterSchema(t *testing.T) {
+	assert := assert.Make(t)
+	defer fixedClock()()
+
+	data, err := ioutil.ReadFile("result.schema.json")
+	assert(err).NoError()
+	var schema map[string]interface{}
+	assert(json.Unmarshal(data, &schema)).NoError()
+
+	for _, name := range []string{"connected.json", "incomplete.json", "failed.json"} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		assert(err).NoError()
+		var doc interface{}
+		assert(json.Unmarshal(data, &doc)).NoError()
+		for _, problem := range validateSchema(schema, schema, doc, "") {
+			t.Errorf("%v: %v", name, problem)
+		}
+		assert(ReadResult(bytes.NewReader(data))).NoError()
+	}
+
+	var bad interface{}
+	assert(json.Unmarshal([]byte(`{"version": 1, "status": "Bogus", "hops": [{"ttl": "1"}], "extra": 1}`), &bad)).NoError()
+	assert(len(validateSchema(schema, schema, bad, "")) > 0).IsTrue()
+}
+
+// validateSchema checks doc against the subset of JSON Schema used by
+// result.schema.json: type, enum, const, required, properties,
+// additionalProperties, items and local $refs.
+func validateSchema(root, schema map[string]interface{}, doc interface{}, path string) (problems []string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		def := root
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			def = def[part].(map[string]interface{})
+		}
+		return validateSchema(root, def, doc, path)
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, v := range enum {
+			found = found || v == doc
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%v: %v not in %v", path, doc, enum))
+		}
+	}
+	if c, ok := schema["const"]; ok && c != doc {
+		problems = append(problems, fmt.Sprintf("%v: %v != %v", path, doc, c))
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return append(problems, fmt.Sprintf("%v: not an object", path))
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		required, _ := schema["required"].([]interface{})
+		for _, r := range required {
+			if _, ok := obj[r.(string)]; !ok {
+				problems = append(problems, fmt.Sprintf("%v: missing %v", path, r))
+			}
+		}
+		var keys []string
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			prop, ok := props[k].(map[string]interface{})
+			if !ok {
+				if schema["additionalProperties"] == false {
+					problems = append(problems, fmt.Sprintf("%v: unexpected %v", path, k))
+				}
+				continue
+			}
+			problems = append(problems, validateSchema(root, prop, obj[k], path+"/"+k)...)
+		}
+	case "array":
+		arr, ok := doc.([]interface{})
+		if !ok {
+			return append(problems, fmt.Sprintf("%v: not an array", path))
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, v := range arr {
+				problems = append(problems, validateSchema(root, items, v, fmt.Sprintf("%v/%d", path, i))...)
+			}
+		}
+	case "string":
+		if _, ok := doc.(string); !ok {
+			problems = append(problems, fmt.Sprintf("%v: not a string", path))
+		}
+	case "integer", "number":
+		f, ok := doc.(float64)
+		if !ok || (schema["type"] == "integer" && f != float64(int64(f))) {
+			problems = append(problems, fmt.Sprintf("%v: not an %v", path, schema["type"]))
+		}
+	case "boolean":
+		if _, ok := doc.(bool); !ok {
+			problems = append(problems, fmt.Sprintf("%v: not a boolean", path))
+		}
+	}
+	return problems
+}
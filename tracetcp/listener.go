@@ -0,0 +1,124 @@
+package tracetcp
+
+import (
+	"log"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// Listener receives the ICMP and TCP replies for any number of traces running
+// at once, so that they share one pair of raw sockets rather than each trace
+// opening its own and being handed every other trace's replies. replies are
+// passed to the traces of the target that sent them, or whose probe they quote.
+type Listener struct {
+	mu   sync.Mutex
+	subs map[*subscription]bool
+	done chan struct{}
+	once sync.Once
+}
+
+// subscription is the replies of one trace's target.
+type subscription struct {
+	target net.IP
+	icmp   chan icmpEvent
+	tcp    chan tcpEvent
+}
+
+// NewListener opens the sockets and starts receiving. it must be closed once
+// the traces using it have finished.
+func NewListener() (*Listener, error) {
+	icmpSock, err := listenICMP()
+	if err != nil {
+		return nil, err
+	}
+	tcpSock, err := listenTCP()
+	if err != nil {
+		syscall.Close(icmpSock)
+		return nil, err
+	}
+
+	l := newListener()
+	icmpChan := make(chan icmpEvent, 100)
+	go receiveICMP(icmpSock, icmpChan, l.done)
+	tcpChan := make(chan tcpEvent, 100)
+	go receiveTCP(tcpSock, tcpChan, l.done)
+	go l.dispatch(icmpChan, tcpChan)
+	return l, nil
+}
+
+func newListener() *Listener {
+	return &Listener{subs: map[*subscription]bool{}, done: make(chan struct{})}
+}
+
+// Close stops receiving and closes the sockets.
+func (l *Listener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *Listener) subscribe(target net.IP) *subscription {
+	s := &subscription{
+		target: target,
+		icmp:   make(chan icmpEvent, 100),
+		tcp:    make(chan tcpEvent, 100),
+	}
+	l.mu.Lock()
+	l.subs[s] = true
+	l.mu.Unlock()
+	return s
+}
+
+func (l *Listener) unsubscribe(s *subscription) {
+	l.mu.Lock()
+	delete(l.subs, s)
+	l.mu.Unlock()
+}
+
+func (l *Listener) dispatch(icmpChan chan icmpEvent, tcpChan chan tcpEvent) {
+	for {
+		select {
+		case ev := <-icmpChan:
+			l.deliverICMP(ev)
+		case ev := <-tcpChan:
+			l.deliverTCP(ev)
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// deliverICMP passes ev to the traces of the target its quoted probe was sent
+// to, or to every trace if it is a socket error. a trace that has fallen 100
+// replies behind misses the reply rather than holding up the others.
+func (l *Listener) deliverICMP(ev icmpEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for s := range l.subs {
+		if ev.evtype != icmpError && !s.target.Equal(ev.probeAddr.IP) {
+			continue
+		}
+		select {
+		case s.icmp <- ev:
+		default:
+			log.Println("Dropped icmp event for", s.target)
+		}
+	}
+}
+
+// deliverTCP passes ev to the traces of the target that sent it, or to every
+// trace if it is a socket error.
+func (l *Listener) deliverTCP(ev tcpEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for s := range l.subs {
+		if ev.err == nil && !s.target.Equal(ev.remoteAddr.IP) {
+			continue
+		}
+		select {
+		case s.tcp <- ev:
+		default:
+			log.Println("Dropped tcp event for", s.target)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package tracetcp
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/0xcafed00d/assert"
+)
+
+func TestListenerRoutesReplies(t *testing.T) {
+	assert := assert.Make(t)
+
+	l := newListener()
+	a := l.subscribe(net.ParseIP("10.0.0.1"))
+	a2 := l.subscribe(net.ParseIP("10.0.0.1"))
+	b := l.subscribe(net.ParseIP("10.0.0.2"))
+
+	expired := icmpEvent{evtype: icmpTTLExpired, localPort: 40000}
+	expired.probeAddr.IP = net.ParseIP("10.0.0.1").To4()
+	l.deliverICMP(expired)
+	assert(len(a.icmp), len(a2.icmp), len(b.icmp)).Equal(1, 1, 0)
+
+	synack := tcpEvent{localPort: 40001}
+	synack.remoteAddr.IP = net.ParseIP("10.0.0.2").To4()
+	l.deliverTCP(synack)
+	assert(len(a.tcp), len(b.tcp)).Equal(0, 1)
+
+	// socket errors go to every trace
+	l.unsubscribe(a2)
+	l.deliverICMP(icmpEvent{evtype: icmpError, err: errors.New("failed")})
+	l.deliverTCP(tcpEvent{err: errors.New("failed")})
+	assert(len(a.icmp), len(a2.icmp), len(b.icmp)).Equal(2, 1, 1)
+	assert(len(a.tcp), len(b.tcp)).Equal(1, 2)
+}
+
+func TestListenerDropsWhenFull(t *testing.T) {
+	assert := assert.Make(t)
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	l := newListener()
+	s := l.subscribe(net.ParseIP("10.0.0.1"))
+	ev := icmpEvent{evtype: icmpTTLExpired}
+	ev.probeAddr.IP = net.ParseIP("10.0.0.1")
+	for i := 0; i < cap(s.icmp)+5; i++ {
+		l.deliverICMP(ev)
+	}
+	assert(len(s.icmp)).Equal(cap(s.icmp))
+}
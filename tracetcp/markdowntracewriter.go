@@ -0,0 +1,59 @@
+package tracetcp
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// MarkdownTraceWriter writes the trace as a GitHub flavoured markdown report,
+// a list of facts about the trace and a table of the hops, for pasting into
+// tickets.
+type MarkdownTraceWriter struct {
+	resultWriter
+}
+
+func (w *MarkdownTraceWriter) Init(config OutputConfig) error {
+	w.render = w.writeMarkdown
+	return w.resultWriter.Init(config)
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`, "*", `\*`, "_", `\_`, "`", "\\`", "<", "&lt;", ">", "&gt;")
+
+func (w *MarkdownTraceWriter) writeMarkdown(r *Result) error {
+	out := bufio.NewWriter(w.config.Out)
+	esc := markdownEscaper.Replace
+
+	fmt.Fprintf(out, "### tracetcp to %v port %v\n\n", esc(r.Target), r.Port)
+	for _, f := range reportFields(r) {
+		fmt.Fprintf(out, "- **%v:** %v\n", f.Name, esc(f.Value))
+	}
+
+	fmt.Fprintf(out, "\n| Hop | Host | Loss | Best | Avg | Worst | Replies |\n")
+	fmt.Fprintf(out, "|----:|:-----|-----:|-----:|----:|------:|:--------|\n")
+	for _, h := range reportHops(r) {
+		hosts := make([]string, len(h.Hosts))
+		for i, host := range h.Hosts {
+			hosts[i] = esc(host)
+		}
+		host := strings.Join(hosts, "<br>")
+		if host == "" {
+			host = `\*`
+		}
+
+		best, avg, worst := "", "", ""
+		if h.Hop.Received > 0 {
+			best = fmt.Sprintf("%.1f ms", millis(h.Hop.RTTMin))
+			avg = fmt.Sprintf("%.1f ms", millis(h.Hop.RTTAvg))
+			worst = fmt.Sprintf("%.1f ms", millis(h.Hop.RTTMax))
+		}
+
+		replies := make([]string, len(h.Probes))
+		for i, p := range h.Probes {
+			replies[i] = esc(p.Text)
+		}
+		fmt.Fprintf(out, "| %v | %v | %.0f%% | %v | %v | %v | %v |\n",
+			h.TTL, host, h.Hop.Loss*100, best, avg, worst, strings.Join(replies, ", "))
+	}
+	return out.Flush()
+}
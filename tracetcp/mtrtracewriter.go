@@ -0,0 +1,184 @@
+package tracetcp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+)
+
+// MTRTraceWriter writes the trace as mtr --report does, or as mtr --json if
+// JSON is set.
+type MTRTraceWriter struct {
+	resultWriter
+	JSON bool
+
+	hostname func() (string, error)
+}
+
+// the default width of the host column in mtr reports
+const mtrHostWidth = 33
+
+func (w *MTRTraceWriter) Init(config OutputConfig) error {
+	w.render = w.writeMTR
+	if w.hostname == nil {
+		w.hostname = os.Hostname
+	}
+	return w.resultWriter.Init(config)
+}
+
+// mtrHop holds the statistics mtr reports for a hop, RTTs in milliseconds.
+type mtrHop struct {
+	ttl   int
+	hosts []string
+	loss  float64
+	sent  int
+	last  float64
+	avg   float64
+	best  float64
+	worst float64
+	stdev float64
+}
+
+func (w *MTRTraceWriter) hops(r *Result) []mtrHop {
+	var hops []mtrHop
+	for _, h := range r.Hops {
+		m := mtrHop{
+			ttl:   h.TTL,
+			loss:  h.Loss * 100,
+			sent:  h.Sent,
+			best:  millis(h.RTTMin),
+			worst: millis(h.RTTMax),
+		}
+
+		var rtts []float64
+		var sum float64
+		for _, p := range h.Probes {
+			if p.Replied() {
+				rtts = append(rtts, millis(p.RTT))
+				sum += millis(p.RTT)
+			}
+		}
+		if len(rtts) > 0 {
+			m.last = rtts[len(rtts)-1]
+			m.avg = sum / float64(len(rtts))
+		}
+		if len(rtts) > 1 {
+			var sumsq float64
+			for _, rtt := range rtts {
+				sumsq += (rtt - m.avg) * (rtt - m.avg)
+			}
+			m.stdev = math.Sqrt(sumsq / float64(len(rtts)-1))
+		}
+
+		for _, addr := range h.Responders {
+			name := addr.String()
+			for _, p := range h.Probes {
+				if p.Addr.Equal(addr) && p.Name != "" {
+					name = p.Name
+					break
+				}
+			}
+			m.hosts = append(m.hosts, name)
+		}
+		if len(m.hosts) == 0 {
+			m.hosts = []string{"???"}
+		}
+		hops = append(hops, m)
+	}
+	return hops
+}
+
+// fit pads or cuts s to exactly width characters.
+func fit(s string, width int) string {
+	return string([]rune(fmt.Sprintf("%-*s", width, s))[:width])
+}
+
+func (w *MTRTraceWriter) writeMTR(r *Result) error {
+	out := bufio.NewWriter(w.config.Out)
+	hostname, _ := w.hostname()
+	if w.JSON {
+		if err := w.writeJSON(out, r, hostname); err != nil {
+			return err
+		}
+	} else {
+		w.writeReport(out, r, hostname)
+	}
+	return out.Flush()
+}
+
+func (w *MTRTraceWriter) writeReport(out *bufio.Writer, r *Result, hostname string) {
+	fmt.Fprintf(out, "Start: %v\n", r.Started.Format("2006-01-02T15:04:05-0700"))
+	fmt.Fprintf(out, "%v Loss%%   Snt   Last   Avg  Best  Wrst StDev\n", fit("HOST: "+hostname, mtrHostWidth))
+
+	for _, h := range w.hops(r) {
+		fmt.Fprintf(out, "%v %4.1f%% %5d  %5.1f %5.1f %5.1f %5.1f %5.1f\n",
+			fit(fmt.Sprintf(" %2d.|-- %v", h.ttl, h.hosts[0]), mtrHostWidth),
+			h.loss, h.sent, h.last, h.avg, h.best, h.worst, h.stdev)
+		for _, host := range h.hosts[1:] {
+			fmt.Fprintf(out, "    |  `|-- %v\n", host)
+		}
+	}
+}
+
+// mtrJSON is the document written by mtr --json. statistics are numbers with
+// two decimal places, as mtr writes them.
+type mtrJSON struct {
+	Report struct {
+		MTR struct {
+			Src        string `json:"src"`
+			Dst        string `json:"dst"`
+			Tos        string `json:"tos"`
+			Psize      string `json:"psize"`
+			Bitpattern string `json:"bitpattern"`
+			Tests      string `json:"tests"`
+		} `json:"mtr"`
+		Hubs []mtrJSONHub `json:"hubs"`
+	} `json:"report"`
+}
+
+type mtrJSONHub struct {
+	Count string      `json:"count"`
+	Host  string      `json:"host"`
+	Loss  json.Number `json:"Loss%"`
+	Snt   int         `json:"Snt"`
+	Last  json.Number `json:"Last"`
+	Avg   json.Number `json:"Avg"`
+	Best  json.Number `json:"Best"`
+	Wrst  json.Number `json:"Wrst"`
+	StDev json.Number `json:"StDev"`
+}
+
+func (w *MTRTraceWriter) writeJSON(out *bufio.Writer, r *Result, hostname string) error {
+	stat := func(f float64) json.Number {
+		return json.Number(strconv.FormatFloat(f, 'f', 2, 64))
+	}
+
+	var doc mtrJSON
+	info := &doc.Report.MTR
+	info.Src, info.Dst = hostname, r.Target
+	info.Tos, info.Bitpattern = "0x0", "0x00"
+	info.Psize = strconv.Itoa(w.config.probeSize())
+	info.Tests = strconv.Itoa(w.config.Queries)
+
+	doc.Report.Hubs = []mtrJSONHub{}
+	for _, h := range w.hops(r) {
+		doc.Report.Hubs = append(doc.Report.Hubs, mtrJSONHub{
+			Count: strconv.Itoa(h.ttl),
+			Host:  h.hosts[0],
+			Loss:  stat(h.loss),
+			Snt:   h.sent,
+			Last:  stat(h.last),
+			Avg:   stat(h.avg),
+			Best:  stat(h.best),
+			Wrst:  stat(h.worst),
+			StDev: stat(h.stdev),
+		})
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,239 @@
+package tracetcp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// NDJSONTraceWriter writes each event as a single line of JSON as soon as it
+// arrives, so a trace can be followed with jq or a log shipper.
+type NDJSONTraceWriter struct {
+	config OutputConfig
+	enc    *json.Encoder
+	now    func() time.Time
+	dest   string
+}
+
+// ndjsonEvent is the form of each line. time is when the probe was sent, or
+// when the line was written for events other than probes. dest is the address
+// traced, so lines of traces to several addresses of a target can be told
+// apart. probe events carry hop and query, durations are in microseconds.
+// options are written, if empty, whenever they are known.
+type ndjsonEvent struct {
+	Time   string         `json:"time"`
+	Event  TraceEventType `json:"event"`
+	Target string         `json:"target"`
+	Port   int            `json:"port"`
+
+	Dest      string `json:"dest,omitempty"`
+	AddrIndex int    `json:"addr_index,omitempty"`
+
+	Hop     *int   `json:"hop,omitempty"`
+	Query   *int   `json:"query,omitempty"`
+	Addr    string `json:"addr,omitempty"`
+	RTT     *int64 `json:"rtt_us,omitempty"`
+	Elapsed *int64 `json:"elapsed_us,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	ReplyTTL    int `json:"reply_ttl,omitempty"`
+	ReverseHops int `json:"reverse_hops,omitempty"`
+	ICMPType    int `json:"icmp_type,omitempty"`
+	ICMPCode    int `json:"icmp_code,omitempty"`
+
+	SentOptions  *string `json:"sent_options,omitempty"`
+	ReplyOptions *string `json:"reply_options,omitempty"`
+
+	Responder    string `json:"responder,omitempty"`
+	ResponderHop int    `json:"responder_hop,omitempty"`
+	Fingerprint  string `json:"fingerprint,omitempty"`
+	Signature    string `json:"signature,omitempty"`
+	FastOpen     string `json:"fast_open,omitempty"`
+
+	// only on TraceStarted
+	StartHop   int    `json:"start_hop,omitempty"`
+	EndHop     int    `json:"end_hop,omitempty"`
+	Queries    int    `json:"queries,omitempty"`
+	ProbeType  string `json:"probe_type,omitempty"`
+	AddrFamily string `json:"addr_family,omitempty"`
+	AddrCount  int    `json:"addr_count,omitempty"`
+}
+
+func (w *NDJSONTraceWriter) Init(config OutputConfig) error {
+	w.config = config
+	w.enc = json.NewEncoder(config.Out)
+	w.dest = ""
+	if config.Addr != nil {
+		w.dest = config.Addr.IP.String()
+	}
+	if w.now == nil {
+		w.now = time.Now
+	}
+	return nil
+}
+
+func (w *NDJSONTraceWriter) Close() error {
+	return nil
+}
+
+func (w *NDJSONTraceWriter) Event(e TraceEvent) error {
+	stamp := e.Sent
+	if stamp.IsZero() {
+		stamp = w.now()
+	}
+	line := ndjsonEvent{
+		Time:   stamp.UTC().Format(time.RFC3339Nano),
+		Event:  e.Type,
+		Target: w.config.Target,
+		Port:   w.config.Port,
+	}
+	if e.Type == TraceStarted && e.Addr.IP != nil {
+		w.dest = e.Addr.IP.String()
+	}
+	line.Dest, line.AddrIndex = w.dest, w.config.AddrIndex
+	if e.Addr.IP != nil {
+		line.Addr = e.Addr.IP.String()
+	}
+	if e.Err != nil {
+		line.Error = e.Err.Error()
+	}
+
+	micros := int64(e.Time / time.Microsecond)
+	switch e.Type {
+	case TraceStarted:
+		line.StartHop = w.config.StartHop
+		line.EndHop = w.config.EndHop
+		line.Queries = w.config.Queries
+		line.ProbeType = w.config.ProbeType.String()
+		if e.Addr.IP != nil {
+			line.AddrFamily = AddrFamily(e.Addr.IP)
+		}
+		line.AddrCount = w.config.AddrCount
+
+	case TimedOut, TTLExpired, Connected, RemoteClosed, Unreachable:
+		hop, query := e.Hop, e.Query
+		line.Hop, line.Query = &hop, &query
+		if e.Type != TimedOut {
+			line.RTT = &micros
+		}
+		line.ReplyTTL = e.ReplyTTL
+		line.ReverseHops = e.ReverseHops
+		line.ICMPType, line.ICMPCode = e.ICMPType, e.ICMPCode
+		if e.SentOptions != nil {
+			sent := e.SentOptions.String()
+			line.SentOptions = &sent
+		}
+		if e.ReplyOptionsSeen {
+			reply := e.ReplyOptions.String()
+			line.ReplyOptions = &reply
+		}
+		if e.Responder.Class != ResponderUnknown {
+			line.Responder = e.Responder.Class.String()
+			line.ResponderHop = e.Responder.Hop
+		}
+		line.Fingerprint = e.Fingerprint.Label
+		line.Signature = e.Fingerprint.Signature
+		if e.FastOpen.Status != FastOpenNotTested {
+			line.FastOpen = e.FastOpen.String()
+		}
+
+	default:
+		line.Elapsed = &micros
+	}
+
+	return w.enc.Encode(line)
+}
+
+// readNDJSON reconstructs the options and events of a trace written by the
+// ndjson writer.
+func readNDJSON(r io.Reader) (Options, []TraceEvent, error) {
+	opts := DefaultOptions
+	var events []TraceEvent
+	var started, last time.Time
+
+	dec := json.NewDecoder(r)
+	for {
+		var line ndjsonEvent
+		err := dec.Decode(&line)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return opts, nil, err
+		}
+
+		stamp, err := time.Parse(time.RFC3339Nano, line.Time)
+		if err != nil {
+			return opts, nil, fmt.Errorf("Invalid time: %v", line.Time)
+		}
+		last = stamp
+
+		e := TraceEvent{Type: line.Event}
+		if line.Addr != "" {
+			e.Addr.IP = net.ParseIP(line.Addr)
+		}
+		if line.Error != "" {
+			e.Err = errors.New(line.Error)
+		}
+		if line.Hop != nil {
+			e.Hop = *line.Hop
+		}
+		if line.Query != nil {
+			e.Query = *line.Query
+		}
+		if line.RTT != nil {
+			e.Time = time.Duration(*line.RTT) * time.Microsecond
+		}
+		if line.Elapsed != nil {
+			e.Time = time.Duration(*line.Elapsed) * time.Microsecond
+		}
+		e.ReplyTTL, e.ReverseHops = line.ReplyTTL, line.ReverseHops
+		e.ICMPType, e.ICMPCode = line.ICMPType, line.ICMPCode
+		if line.SentOptions != nil {
+			if e.SentOptions, err = ParseTCPOptions(*line.SentOptions); err != nil {
+				return opts, nil, fmt.Errorf("Invalid sent_options: %v", err)
+			}
+		}
+		if line.ReplyOptions != nil {
+			if e.ReplyOptions, err = ParseTCPOptions(*line.ReplyOptions); err != nil {
+				return opts, nil, fmt.Errorf("Invalid reply_options: %v", err)
+			}
+			e.ReplyOptionsSeen = true
+		}
+		if line.Responder != "" {
+			e.Responder.Class.UnmarshalText([]byte(line.Responder))
+			e.Responder.Hop = line.ResponderHop
+		}
+		e.Fingerprint.Label, e.Fingerprint.Signature = line.Fingerprint, line.Signature
+		if line.FastOpen != "" {
+			// the status leads the description of the result
+			e.FastOpen.Status.UnmarshalText([]byte(strings.SplitN(line.FastOpen, ",", 2)[0]))
+		}
+
+		switch line.Event {
+		case TimedOut, TTLExpired, Connected, RemoteClosed, Unreachable:
+			e.Sent = stamp
+		case TraceStarted:
+			opts.Target, opts.Port = line.Target, line.Port
+			opts.Addr = &net.IPAddr{IP: e.Addr.IP}
+			opts.StartHop, opts.EndHop, opts.Queries = line.StartHop, line.EndHop, line.Queries
+			opts.ProbeType, _ = ParseProbeType(line.ProbeType)
+			opts.AddrIndex, opts.AddrCount = line.AddrIndex, line.AddrCount
+			e.Sent, started = stamp, stamp
+		}
+		events = append(events, e)
+	}
+
+	if len(events) == 0 || events[0].Type != TraceStarted {
+		return opts, nil, fmt.Errorf("Trace does not begin with TraceStarted")
+	}
+	if events[len(events)-1].Type != TraceComplete {
+		// the trace was cut short, so ran for at least as long as it was written
+		events = append(events, TraceEvent{Type: TraceComplete, Time: last.Sub(started)})
+	}
+	return opts, events, nil
+}
@@ -0,0 +1,43 @@
+package tracetcp
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xcafed00d/assert"
+)
+
+func TestNDJSONTraceWriter(t *testing.T) {
+	assert := assert.Make(t)
+
+	var out bytes.Buffer
+	opts := DefaultOptions
+	opts.Target = "test.example.com"
+	opts.AddrIndex, opts.AddrCount = 2, 3
+
+	w := &NDJSONTraceWriter{now: func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }}
+	assert(w.Init(OutputConfig{Options: opts, Out: &out})).NoError()
+
+	events := testTraceEvents()
+	assert(w.Event(events[0])).NoError()
+	assert(out.String()).Equal(`{"time":"2020-01-02T03:04:05Z","event":"TraceStarted","target":"test.example.com","port":80,` +
+		`"dest":"10.0.0.9","addr_index":2,"addr":"10.0.0.9","start_hop":1,"end_hop":30,"queries":3,"probe_type":"connect",` +
+		`"addr_family":"ipv4","addr_count":3}` + "\n")
+
+	out.Reset()
+	assert(w.Event(events[1])).NoError()
+	assert(w.Event(events[3])).NoError()
+	assert(w.Event(TraceEvent{Type: TraceFailed, Err: errors.New("network is unreachable"), Time: time.Second})).NoError()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert(len(lines)).Equal(3)
+	assert(lines[0]).Equal(`{"time":"2020-01-02T03:04:05Z","event":"TTLExpired","target":"test.example.com","port":80,` +
+		`"dest":"10.0.0.9","addr_index":2,"hop":1,"query":0,"addr":"10.0.0.1","rtt_us":1000,"reply_ttl":64,"reverse_hops":1}`)
+	assert(lines[1]).Equal(`{"time":"2020-01-02T03:04:05Z","event":"TimedOut","target":"test.example.com","port":80,` +
+		`"dest":"10.0.0.9","addr_index":2,"hop":1,"query":2}`)
+	assert(lines[2]).Equal(`{"time":"2020-01-02T03:04:05Z","event":"TraceFailed","target":"test.example.com","port":80,` +
+		`"dest":"10.0.0.9","addr_index":2,"elapsed_us":1000000,"error":"network is unreachable"}`)
+}
@@ -0,0 +1,156 @@
+package tracetcp
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Options controls a trace started with Trace.Start or Run.
+type Options struct {
+	// host name or address to trace to. Addr may be set instead to skip the
+	// lookup.
+	Target string
+	Addr   *net.IPAddr
+	Port   int
+
+	// position of Addr among the addresses Target resolves to, from 1, and
+	// the number of addresses. set by the lookup when Addr is not given.
+	AddrIndex int
+	AddrCount int
+
+	StartHop int
+	EndHop   int
+	Queries  int
+
+	// how long to wait for a reply to each probe
+	Timeout time.Duration
+
+	// SYN probes are sent with DefaultTCPOptions if no options are given.
+	// options can not be set on connect probes.
+	ProbeType  ProbeType
+	TCPOptions TCPOptions
+
+	// local address to send probes from. the kernel chooses if nil.
+	Source net.IP
+
+	// test TCP Fast Open once the destination is reached. syn probes only.
+	FastOpen bool
+
+	// signatures used to identify the destination's stack, DefaultFingerprints
+	// if nil
+	Fingerprints *FingerprintDB
+}
+
+var DefaultOptions = Options{
+	Port:     80,
+	StartHop: 1,
+	EndHop:   30,
+	Queries:  3,
+	Timeout:  time.Second,
+}
+
+func (o *Options) Validate() error {
+	if o.Target == "" && o.Addr == nil {
+		return fmt.Errorf("No target given")
+	}
+	if o.Addr != nil && o.Addr.IP.To4() == nil {
+		return fmt.Errorf("Only IPv4 targets are supported: %v", o.Addr)
+	}
+	if o.Port < 1 || o.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535")
+	}
+	if o.StartHop < 1 {
+		return fmt.Errorf("start hop must be >= 1")
+	}
+	if o.EndHop > 255 {
+		return fmt.Errorf("end hop must be <= 255")
+	}
+	if o.EndHop < o.StartHop {
+		return fmt.Errorf("end hop must be >= start hop")
+	}
+	if o.Queries < 1 {
+		return fmt.Errorf("queries must be >= 1")
+	}
+	if o.Timeout <= 0 {
+		return fmt.Errorf("timeout must be > 0")
+	}
+	if o.ProbeType != ProbeConnect && o.ProbeType != ProbeSYN {
+		return fmt.Errorf("Invalid probe type: %v", o.ProbeType)
+	}
+	if o.ProbeType == ProbeConnect && len(o.TCPOptions) > 0 {
+		// the kernel chooses the options of a connect probe, so what was sent
+		// is not known to compare with what routers quote back
+		return fmt.Errorf("TCP options can only be set on syn probes")
+	}
+	if o.ProbeType != ProbeSYN && o.FastOpen {
+		// the cookie request is carried by the options of the syn probes
+		return fmt.Errorf("TCP Fast Open can only be tested with syn probes")
+	}
+	if _, err := o.TCPOptions.Marshal(); err != nil {
+		return err
+	}
+	if o.Source != nil && o.Source.To4() == nil {
+		return fmt.Errorf("Only IPv4 source addresses are supported: %v", o.Source)
+	}
+	return nil
+}
+
+// resolve looks up Target if Addr has not been given.
+func (o *Options) resolve() error {
+	if o.Addr != nil {
+		return nil
+	}
+	addrs, err := LookupAddresses(o.Target)
+	if err != nil {
+		return err
+	}
+	return o.chooseAddr(addrs)
+}
+
+// chooseAddr sets Addr to the first IPv4 address of addrs, the only family
+// that can be traced, and AddrIndex to its position among them.
+func (o *Options) chooseAddr(addrs []*net.IPAddr) error {
+	for i, addr := range addrs {
+		if addr.IP.To4() != nil {
+			o.Addr, o.AddrIndex, o.AddrCount = addr, i+1, len(addrs)
+			return nil
+		}
+	}
+	return fmt.Errorf("%v has no IPv4 addresses", o.Target)
+}
+
+// probeOptions returns the TCP options carried by each probe.
+func (o *Options) probeOptions() TCPOptions {
+	opts := o.TCPOptions
+	if o.ProbeType == ProbeSYN && len(opts) == 0 {
+		opts = DefaultTCPOptions
+	}
+	if o.ProbeType == ProbeSYN && o.FastOpen {
+		// carry a cookie request on every probe so routers quoting the header
+		// show where the option is stripped
+		opts = opts.With(TCPOption{Kind: TCPOptFastOpen})
+	}
+	return opts
+}
+
+// probeSize is the size of the IP packet carrying each SYN.
+func (o *Options) probeSize() int {
+	if o.ProbeType != ProbeSYN {
+		// the options linux adds to a SYN
+		return ipHeaderLen + tcpHeaderLen + 20
+	}
+	opts, _ := o.probeOptions().Marshal()
+	return ipHeaderLen + tcpHeaderLen + len(opts)
+}
+
+func (o *Options) fingerprintDB() *FingerprintDB {
+	if o.Fingerprints != nil {
+		return o.Fingerprints
+	}
+	return DefaultFingerprints
+}
+
+func (o *Options) source() net.IPAddr {
+	return net.IPAddr{IP: o.Source}
+}
@@ -0,0 +1,92 @@
+package tracetcp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/0xcafed00d/assert"
+)
+
+func TestValidateOptions(t *testing.T) {
+	assert := assert.Make(t)
+
+	opts := DefaultOptions
+	opts.Target = "www.google.com"
+	assert(opts.Validate()).NoError()
+	assert((&Options{}).Validate()).HasError()
+
+	cfg := opts
+	cfg.Target = ""
+	assert(cfg.Validate()).HasError()
+	cfg.Addr = &net.IPAddr{IP: net.IPv4(8, 8, 8, 8)}
+	assert(cfg.Validate()).NoError()
+	cfg.Addr = &net.IPAddr{IP: net.ParseIP("2001:db8::1")}
+	assert(cfg.Validate()).HasError()
+
+	cfg = opts
+	cfg.Port = 0
+	assert(cfg.Validate()).HasError()
+
+	cfg = opts
+	cfg.StartHop = 0
+	assert(cfg.Validate()).HasError()
+
+	cfg = opts
+	cfg.EndHop = 256
+	assert(cfg.Validate()).HasError()
+
+	cfg = opts
+	cfg.StartHop, cfg.EndHop = 10, 9
+	assert(cfg.Validate()).HasError()
+
+	cfg = opts
+	cfg.Queries = 0
+	assert(cfg.Validate()).HasError()
+
+	cfg = opts
+	cfg.Timeout = 0
+	assert(cfg.Validate()).HasError()
+
+	cfg = opts
+	cfg.ProbeType = ProbeType(7)
+	assert(cfg.Validate()).HasError()
+
+	cfg = opts
+	cfg.ProbeType = ProbeSYN
+	cfg.TCPOptions = TCPOptions{{Kind: TCPOptFastOpen, Data: make([]byte, 40)}}
+	assert(cfg.Validate()).HasError()
+
+	cfg = opts
+	cfg.TCPOptions = TCPOptions{{Kind: TCPOptMSS, Data: []byte{0x05, 0x50}}}
+	assert(cfg.Validate()).HasError()
+	cfg.ProbeType = ProbeSYN
+	assert(cfg.Validate()).NoError()
+
+	cfg = opts
+	cfg.FastOpen = true
+	assert(cfg.Validate()).HasError()
+	cfg.ProbeType = ProbeSYN
+	assert(cfg.Validate()).NoError()
+
+	cfg = opts
+	cfg.Source = net.ParseIP("::1")
+	assert(cfg.Validate()).HasError()
+}
+
+func TestChooseAddr(t *testing.T) {
+	assert := assert.Make(t)
+
+	v4 := &net.IPAddr{IP: net.IPv4(10, 0, 0, 1).To4()}
+	v6 := &net.IPAddr{IP: net.ParseIP("2001:db8::1")}
+
+	opts := DefaultOptions
+	opts.Target = "www.example.com"
+	assert(opts.chooseAddr([]*net.IPAddr{v6, v4})).NoError()
+	assert(opts.Addr, opts.AddrIndex, opts.AddrCount).Equal(v4, 2, 2)
+	assert(opts.Validate()).NoError()
+
+	opts = DefaultOptions
+	opts.Target = "www.example.com"
+	assert(opts.chooseAddr([]*net.IPAddr{v6})).HasError()
+	assert(opts.Addr == nil).IsTrue()
+}
@@ -0,0 +1,166 @@
+package tracetcp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrometheusTraceWriter writes the trace as OpenMetrics text. given a file
+// name it replaces the file atomically once the trace is done, so that
+// node_exporter's textfile collector never reads a partial file.
+type PrometheusTraceWriter struct {
+	resultWriter
+	file string
+}
+
+func (w *PrometheusTraceWriter) Usage() string {
+	return "prometheus[=FILE]"
+}
+
+// Configure takes the file to write, standard output if empty.
+func (w *PrometheusTraceWriter) Configure(sep byte, arg string) error {
+	if sep == ':' {
+		return fmt.Errorf("the file is given as prometheus=FILE")
+	}
+	w.file = arg
+	return nil
+}
+
+func (w *PrometheusTraceWriter) Init(config OutputConfig) error {
+	w.render = w.writeMetrics
+	return w.resultWriter.Init(config)
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabels formats name, value pairs as a label set.
+func promLabels(pairs ...string) string {
+	var labels []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%v="%v"`, pairs[i], promLabelEscaper.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func promValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func promBool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type promSample struct {
+	labels string
+	value  float64
+}
+
+type promFamily struct {
+	name    string
+	unit    string
+	help    string
+	samples []promSample
+}
+
+func (w *PrometheusTraceWriter) families(r *Result) []*promFamily {
+	target, port := r.Target, strconv.Itoa(r.Port)
+	traceLabels := promLabels("target", target, "port", port)
+
+	rtt := &promFamily{name: "tracetcp_hop_rtt_seconds", unit: "seconds",
+		help: "Average round trip time of the replies from a responder at a hop."}
+	loss := &promFamily{name: "tracetcp_hop_loss_ratio",
+		help: "Fraction of the probes sent to a hop that were not answered."}
+
+	hopCount := 0
+	for _, h := range r.Hops {
+		hopCount = h.TTL
+		hop := strconv.Itoa(h.TTL)
+		for _, addr := range h.Responders {
+			var sum time.Duration
+			n := 0
+			for _, p := range h.Probes {
+				if p.Replied() && p.Addr.Equal(addr) {
+					sum += p.RTT
+					n++
+				}
+			}
+			rtt.samples = append(rtt.samples, promSample{
+				promLabels("target", target, "port", port, "hop", hop, "responder", addr.String()),
+				(sum / time.Duration(n)).Seconds()})
+		}
+		loss.samples = append(loss.samples, promSample{
+			promLabels("target", target, "port", port, "hop", hop), h.Loss})
+	}
+	if r.Destination != nil {
+		hopCount = r.Destination.Hop
+	}
+
+	return []*promFamily{
+		rtt,
+		loss,
+		{name: "tracetcp_hop_count", help: "Hops to the destination, or the last hop probed if it was not reached.",
+			samples: []promSample{{traceLabels, float64(hopCount)}}},
+		{name: "tracetcp_destination_reached", help: "Whether the trace reached the destination.",
+			samples: []promSample{{traceLabels, promBool(r.Reached)}}},
+		{name: "tracetcp_trace_duration_seconds", unit: "seconds", help: "Time taken by the trace.",
+			samples: []promSample{{traceLabels, r.Duration.Seconds()}}},
+		{name: "tracetcp_trace_timestamp_seconds", unit: "seconds", help: "Time the trace finished.",
+			samples: []promSample{{traceLabels, float64(r.Finished.UnixNano()) / float64(time.Second)}}},
+	}
+}
+
+func (w *PrometheusTraceWriter) writeMetrics(r *Result) error {
+	var out bytes.Buffer
+	for _, f := range w.families(r) {
+		fmt.Fprintf(&out, "# TYPE %v gauge\n", f.name)
+		if f.unit != "" {
+			fmt.Fprintf(&out, "# UNIT %v %v\n", f.name, f.unit)
+		}
+		fmt.Fprintf(&out, "# HELP %v %v\n", f.name, f.help)
+		for _, s := range f.samples {
+			fmt.Fprintf(&out, "%v%v %v\n", f.name, s.labels, promValue(s.value))
+		}
+	}
+	fmt.Fprintf(&out, "# EOF\n")
+
+	if w.file == "" {
+		_, err := w.config.Out.Write(out.Bytes())
+		return err
+	}
+	return replaceFile(w.file, out.Bytes())
+}
+
+// replaceFile writes data to a temporary file in the same directory as name
+// and renames it over name, so readers see either the old or new contents.
+func replaceFile(name string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+package tracetcp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xcafed00d/assert"
+)
+
+func TestPrometheusTraceWriterGolden(t *testing.T) {
+	defer fixedClock()()
+
+	w := &PrometheusTraceWriter{}
+	w.lookup = testLookup
+	checkGolden(t, "connected.prom", writeGraphTrace(w, testTraceEvents()))
+}
+
+func TestPrometheusTraceWriterFile(t *testing.T) {
+	assert := assert.Make(t)
+	defer fixedClock()()
+
+	dir, err := ioutil.TempDir("", "tracetcp")
+	assert(err).NoError()
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "tracetcp.prom")
+	assert(ioutil.WriteFile(name, []byte("old\n"), 0644)).NoError()
+
+	w := &PrometheusTraceWriter{}
+	assert(w.Configure('=', name)).NoError()
+	assert(len(writeGraphTrace(w, testTraceEvents()))).Equal(0)
+
+	data, err := ioutil.ReadFile(name)
+	assert(err).NoError()
+	assert(string(data[len(data)-6:])).Equal("# EOF\n")
+
+	// only the metrics file is left behind
+	files, err := ioutil.ReadDir(dir)
+	assert(err).NoError()
+	assert(len(files)).Equal(1)
+
+	info, err := os.Stat(name)
+	assert(err).NoError()
+	assert(info.Mode().Perm()).Equal(os.FileMode(0644))
+}
@@ -0,0 +1,147 @@
+package tracetcp
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// the markdown and HTML writers lay out the same report: a list of facts
+// about the trace followed by a row for each hop.
+
+type reportField struct {
+	Name  string
+	Value string
+}
+
+type reportProbe struct {
+	Text    string
+	RTT     time.Duration
+	Replied bool
+	Detail  string
+}
+
+type reportHop struct {
+	TTL    int
+	Hosts  []string
+	Hop    Hop
+	Probes []reportProbe
+}
+
+// hostLabel returns "name (addr)", or just the address if it has no name.
+func hostLabel(addr net.IP, name string) string {
+	if name == "" {
+		return addr.String()
+	}
+	return fmt.Sprintf("%v (%v)", name, addr)
+}
+
+// hopHosts returns the label of each address that answered at h.
+func hopHosts(h Hop) []string {
+	var hosts []string
+	for _, addr := range h.Responders {
+		name := ""
+		for _, p := range h.Probes {
+			if p.Addr.Equal(addr) && p.Name != "" {
+				name = p.Name
+				break
+			}
+		}
+		hosts = append(hosts, hostLabel(addr, name))
+	}
+	return hosts
+}
+
+// traceSummary describes how the trace ended.
+func traceSummary(r *Result) string {
+	switch r.Status {
+	case Connected:
+		return fmt.Sprintf("Connected to port %v at hop %v", r.Port, r.Destination.Hop)
+	case RemoteClosed:
+		return fmt.Sprintf("Port %v closed at hop %v", r.Port, r.Destination.Hop)
+	case Unreachable:
+		for _, h := range r.Hops {
+			for _, p := range h.Probes {
+				if p.Outcome == Unreachable {
+					return fmt.Sprintf("Destination unreachable %v from %v at hop %v",
+						UnreachableAnnotation(p.ICMPCode), p.Addr, h.TTL)
+				}
+			}
+		}
+		return "Destination unreachable"
+	case TraceAborted:
+		return "Trace aborted"
+	case TraceFailed:
+		return "Trace failed: " + r.Error
+	}
+	return "Destination not reached"
+}
+
+// reportFields returns the facts listed above the hops.
+func reportFields(r *Result) []reportField {
+	dest := r.Target
+	if addr := r.Addr.String(); r.Addr != nil && addr != dest {
+		dest = fmt.Sprintf("%v (%v)", dest, addr)
+	}
+	fields := []reportField{
+		{"Destination", fmt.Sprintf("%v port %v", dest, r.Port)},
+		{"Result", traceSummary(r)},
+		{"Started", r.Started.Format(time.RFC3339)},
+		{"Duration", r.Duration.Round(time.Millisecond).String()},
+	}
+	if r.LocalAddr != nil {
+		fields = append(fields, reportField{"Source", r.LocalAddr.String()})
+	}
+	if o := r.Options; o != nil {
+		probes := fmt.Sprintf("%v, hops %v to %v, %v per hop, timeout %v",
+			o.ProbeType, o.StartHop, o.EndHop, o.Queries, o.Timeout)
+		if o.TCPOptions != "" {
+			probes += ", options " + o.TCPOptions
+		}
+		fields = append(fields, reportField{"Probes", probes})
+	}
+	if d := r.Destination; d != nil {
+		if d.Responder != ResponderUnknown {
+			fields = append(fields, reportField{"Responder", d.Responder.String()})
+		}
+		if d.Fingerprint != "" {
+			fields = append(fields, reportField{"Fingerprint", d.Fingerprint})
+		}
+		if d.FastOpen != FastOpenNotTested {
+			fields = append(fields, reportField{"TCP Fast Open", d.FastOpen.String()})
+		}
+	}
+	if r.Tool != "" {
+		fields = append(fields, reportField{"Tool", r.Tool})
+	}
+	return fields
+}
+
+// reportHops returns a row for each hop of the trace.
+func reportHops(r *Result) []reportHop {
+	var hops []reportHop
+	for _, h := range r.Hops {
+		rh := reportHop{TTL: h.TTL, Hosts: hopHosts(h), Hop: h}
+		for _, p := range h.Probes {
+			if !p.Replied() {
+				rh.Probes = append(rh.Probes, reportProbe{Text: "*", Detail: "timed out"})
+				continue
+			}
+			rp := reportProbe{
+				Text:    fmt.Sprintf("%.1f ms", millis(p.RTT)),
+				RTT:     p.RTT,
+				Replied: true,
+				Detail:  fmt.Sprintf("%v from %v", p.Outcome, p.Addr),
+			}
+			if ann := UnreachableAnnotation(p.ICMPCode); p.Outcome == Unreachable && ann != "" {
+				rp.Text += " " + ann
+			}
+			if p.ReplyTTL != 0 {
+				rp.Detail += fmt.Sprintf(", reply TTL %v", p.ReplyTTL)
+			}
+			rh.Probes = append(rh.Probes, rp)
+		}
+		hops = append(hops, rh)
+	}
+	return hops
+}
@@ -0,0 +1,21 @@
+package tracetcp
+
+import (
+	"testing"
+)
+
+func TestReportWritersGolden(t *testing.T) {
+	defer fixedClock()()
+
+	md := &MarkdownTraceWriter{}
+	md.lookup = testLookup
+	checkGolden(t, "connected.md", writeGraphTrace(md, testTraceEvents()))
+
+	md = &MarkdownTraceWriter{}
+	md.lookup = testLookup
+	checkGolden(t, "unreachable.md", writeGraphTrace(md, unreachableTraceEvents()))
+
+	html := &HTMLTraceWriter{}
+	html.lookup = testLookup
+	checkGolden(t, "connected.html", writeGraphTrace(html, testTraceEvents()))
+}
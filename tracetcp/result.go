@@ -0,0 +1,464 @@
+package tracetcp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// ResultVersion is the version of the Result JSON schema, which is published
+// in result.schema.json. it is incremented whenever a field is removed or
+// changes meaning; new fields may be added without a change of version.
+//
+// a Result is encoded as:
+//
+//	{
+//	  "version": 1,
+//	  "tool": "tracetcp-go dev",
+//	  "target": "www.example.com",   host name or address given
+//	  "addr": "93.184.216.34",       address traced to
+//	  "addr_name": "example.com",    reverse DNS name, if looked up
+//	  "port": 80,
+//	  "local_addr": "192.168.1.10",
+//	  "options": {
+//	    "start_hop": 1, "end_hop": 30, "queries": 3, "timeout_ns": 1000000000,
+//	    "probe_type": "syn", "tcp_options": "mss=1460,sack", "source": "192.168.1.10",
+//	    "fast_open": true
+//	  },
+//	  "started": "2020-01-02T03:04:05Z",
+//	  "finished": "2020-01-02T03:04:06.234Z",
+//	  "status": "Connected",         Connected, RemoteClosed, TimedOut,
+//	                                 Unreachable, TraceAborted or TraceFailed
+//	  "reached": true,
+//	  "error": "...",                only when status is TraceFailed
+//	  "duration_ns": 1234000000,
+//	  "hops": [{
+//	    "ttl": 1,
+//	    "sent": 3, "received": 3, "loss": 0,
+//	    "rtt_min_ns": 900000, "rtt_avg_ns": 1000000, "rtt_max_ns": 1100000,
+//	    "responders": ["192.168.1.1"],
+//	    "option_changes": [{         changes to the options first seen here
+//	      "type": "modified", "change": "mss=1460 -> mss=1360", "prev_hop": 0,
+//	      "responder": "192.168.1.1"
+//	    }],
+//	    "probes": [{
+//	      "query": 0, "outcome": "TTLExpired", "addr": "192.168.1.1", "name": "router.lan",
+//	      "rtt_ns": 900000, "sent_ns": 1200000, "reply_ttl": 64, "reverse_hops": 1,
+//	      "icmp_type": 11, "icmp_code": 0,
+//	      "sent_options": "mss=1460,sack", "reply_options": "mss=1360,sack"
+//	    }]
+//	  }],
+//	  "destination": {               only when reached
+//	    "hop": 12, "addr": "93.184.216.34", "name": "example.com", "outcome": "Connected",
+//	    "rtt_ns": 1000000, "responder": "destination", "responder_hop": 0,
+//	    "fingerprint": "s:unix:Linux:3.11 and newer", "signature": "4:64:0:...",
+//	    "fast_open": "working"
+//	  }
+//	}
+//
+// all durations are integer nanoseconds. rtt fields are omitted when no probe
+// at the hop received a reply.
+const ResultVersion = 1
+
+// Probe is the outcome of a single query.
+type Probe struct {
+	Query   int            `json:"query"`
+	Outcome TraceEventType `json:"outcome"`
+	Addr    net.IP         `json:"addr,omitempty"`
+	Name    string         `json:"name,omitempty"`
+	RTT     time.Duration  `json:"rtt_ns"`
+
+	// when the probe was sent, after Result.Started
+	Sent time.Duration `json:"sent_ns,omitempty"`
+
+	ReplyTTL    int `json:"reply_ttl,omitempty"`
+	ReverseHops int `json:"reverse_hops,omitempty"`
+
+	ICMPType int `json:"icmp_type,omitempty"`
+	ICMPCode int `json:"icmp_code,omitempty"`
+
+	// as written by TCPOptions.String, nil when not known
+	SentOptions  *string `json:"sent_options,omitempty"`
+	ReplyOptions *string `json:"reply_options,omitempty"`
+}
+
+// Replied is false for a probe that timed out.
+func (p Probe) Replied() bool {
+	return p.Outcome != TimedOut
+}
+
+// Hop holds the probes sent with the same TTL and statistics over those that
+// were answered.
+type Hop struct {
+	TTL    int     `json:"ttl"`
+	Probes []Probe `json:"probes"`
+
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	Loss     float64 `json:"loss"`
+
+	RTTMin time.Duration `json:"rtt_min_ns,omitempty"`
+	RTTAvg time.Duration `json:"rtt_avg_ns,omitempty"`
+	RTTMax time.Duration `json:"rtt_max_ns,omitempty"`
+
+	// every address that replied at this hop, in the order first seen
+	Responders []net.IP `json:"responders"`
+
+	OptionChanges []OptionChange `json:"option_changes,omitempty"`
+}
+
+// OptionChange is a change to the sent TCP options first seen at a hop.
+type OptionChange struct {
+	Type      string `json:"type"`
+	Change    string `json:"change"`
+	PrevHop   int    `json:"prev_hop"`
+	Responder net.IP `json:"responder"`
+}
+
+func (h *Hop) add(p Probe) {
+	h.Probes = append(h.Probes, p)
+	h.Sent++
+	if !p.Replied() {
+		h.Loss = 1 - float64(h.Received)/float64(h.Sent)
+		return
+	}
+
+	if h.Received == 0 || p.RTT < h.RTTMin {
+		h.RTTMin = p.RTT
+	}
+	if p.RTT > h.RTTMax {
+		h.RTTMax = p.RTT
+	}
+	h.RTTAvg = (h.RTTAvg*time.Duration(h.Received) + p.RTT) / time.Duration(h.Received+1)
+	h.Received++
+	h.Loss = 1 - float64(h.Received)/float64(h.Sent)
+
+	if p.Addr != nil && !containsIP(h.Responders, p.Addr) {
+		h.Responders = append(h.Responders, p.Addr)
+	}
+}
+
+// Destination records how the destination answered.
+type Destination struct {
+	Hop     int            `json:"hop"`
+	Addr    net.IP         `json:"addr"`
+	Name    string         `json:"name,omitempty"`
+	Outcome TraceEventType `json:"outcome"`
+	RTT     time.Duration  `json:"rtt_ns"`
+
+	Responder    ResponderClass `json:"responder"`
+	ResponderHop int            `json:"responder_hop,omitempty"`
+
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Signature   string `json:"signature,omitempty"`
+
+	FastOpen FastOpenStatus `json:"fast_open,omitempty"`
+}
+
+// ResultOptions records the Options a trace was run with.
+type ResultOptions struct {
+	StartHop   int           `json:"start_hop"`
+	EndHop     int           `json:"end_hop"`
+	Queries    int           `json:"queries"`
+	Timeout    time.Duration `json:"timeout_ns"`
+	ProbeType  ProbeType     `json:"probe_type"`
+	TCPOptions string        `json:"tcp_options,omitempty"`
+	Source     net.IP        `json:"source,omitempty"`
+	FastOpen   bool          `json:"fast_open,omitempty"`
+}
+
+// Result is the outcome of a trace, built from its events. see ResultVersion
+// for the JSON form.
+type Result struct {
+	Version   int            `json:"version"`
+	Tool      string         `json:"tool,omitempty"`
+	Target    string         `json:"target"`
+	Addr      net.IP         `json:"addr"`
+	AddrName  string         `json:"addr_name,omitempty"`
+	Port      int            `json:"port"`
+	LocalAddr net.IP         `json:"local_addr,omitempty"`
+	Options   *ResultOptions `json:"options,omitempty"`
+
+	// ipv4 or ipv6, and which of the addresses target resolved to was traced
+	AddrFamily string `json:"addr_family,omitempty"`
+	AddrIndex  int    `json:"addr_index,omitempty"`
+	AddrCount  int    `json:"addr_count,omitempty"`
+
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+
+	// Connected or RemoteClosed if the destination was reached, TimedOut if
+	// the hop limit was hit first, Unreachable if a router reported the
+	// destination unreachable, or TraceAborted or TraceFailed.
+	Status  TraceEventType `json:"status"`
+	Reached bool           `json:"reached"`
+	Error   string         `json:"error,omitempty"`
+
+	Duration time.Duration `json:"duration_ns"`
+	Hops     []Hop         `json:"hops"`
+
+	Destination *Destination `json:"destination,omitempty"`
+}
+
+// overridden by tests for repeatable timestamps
+var timeNow = time.Now
+
+// NewResult returns an empty result for a trace run with opts.
+func NewResult(opts Options) *Result {
+	r := &Result{
+		Version:   ResultVersion,
+		Tool:      "tracetcp-go " + Version,
+		Target:    opts.Target,
+		Port:      opts.Port,
+		AddrIndex: opts.AddrIndex,
+		AddrCount: opts.AddrCount,
+		Hops:      []Hop{},
+		Options: &ResultOptions{
+			StartHop:  opts.StartHop,
+			EndHop:    opts.EndHop,
+			Queries:   opts.Queries,
+			Timeout:   opts.Timeout,
+			ProbeType: opts.ProbeType,
+			Source:    opts.Source,
+			FastOpen:  opts.FastOpen,
+		},
+	}
+	if tcpOpts := opts.probeOptions(); len(tcpOpts) > 0 {
+		r.Options.TCPOptions = tcpOpts.String()
+	}
+	if r.Target == "" && opts.Addr != nil {
+		r.Target = opts.Addr.String()
+	}
+	return r
+}
+
+// ReadResult decodes a Result written as JSON, rejecting schema versions it
+// does not understand.
+func ReadResult(r io.Reader) (*Result, error) {
+	result := &Result{}
+	if err := json.NewDecoder(r).Decode(result); err != nil {
+		return nil, err
+	}
+	if result.Version < 1 || result.Version > ResultVersion {
+		return nil, fmt.Errorf("Unsupported result version: %v", result.Version)
+	}
+	for _, h := range result.Hops {
+		for _, p := range h.Probes {
+			for _, opts := range []*string{p.SentOptions, p.ReplyOptions} {
+				if opts == nil {
+					continue
+				}
+				if _, err := ParseTCPOptions(*opts); err != nil {
+					return nil, fmt.Errorf("Hop %d query %d: %v", h.TTL, p.Query, err)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+func (r *Result) hop(ttl int) *Hop {
+	if n := len(r.Hops); n > 0 && r.Hops[n-1].TTL == ttl {
+		return &r.Hops[n-1]
+	}
+	r.Hops = append(r.Hops, Hop{TTL: ttl, Probes: []Probe{}, Responders: []net.IP{}})
+	return &r.Hops[len(r.Hops)-1]
+}
+
+// Add updates the result with the next event of a trace.
+func (r *Result) Add(e TraceEvent) {
+	switch e.Type {
+	case TraceStarted:
+		r.Addr = e.Addr.IP
+		if r.Addr != nil {
+			r.AddrFamily = AddrFamily(r.Addr)
+		}
+		r.LocalAddr = e.Source.IP
+		if r.Target == "" {
+			r.Target = e.Addr.String()
+		}
+		r.Started = e.Sent
+		if r.Started.IsZero() {
+			r.Started = timeNow()
+		}
+		r.Status = TimedOut
+
+	case TimedOut, TTLExpired, Connected, RemoteClosed, Unreachable:
+		p := Probe{
+			Query:       e.Query,
+			Outcome:     e.Type,
+			Addr:        e.Addr.IP,
+			RTT:         e.Time,
+			ReplyTTL:    e.ReplyTTL,
+			ReverseHops: e.ReverseHops,
+			ICMPType:    e.ICMPType,
+			ICMPCode:    e.ICMPCode,
+		}
+		if !e.Sent.IsZero() {
+			p.Sent = e.Sent.Sub(r.Started)
+		}
+		if e.SentOptions != nil {
+			sent := e.SentOptions.String()
+			p.SentOptions = &sent
+		}
+		if e.ReplyOptionsSeen {
+			reply := e.ReplyOptions.String()
+			p.ReplyOptions = &reply
+		}
+		r.hop(e.Hop).add(p)
+		if e.Type == Unreachable {
+			r.Status = Unreachable
+		}
+		if e.Type == Connected || e.Type == RemoteClosed {
+			r.Status = e.Type
+			r.Reached = true
+			r.Destination = &Destination{
+				Hop:          e.Hop,
+				Addr:         e.Addr.IP,
+				Outcome:      e.Type,
+				RTT:          e.Time,
+				Responder:    e.Responder.Class,
+				ResponderHop: e.Responder.Hop,
+				Fingerprint:  e.Fingerprint.Label,
+				Signature:    e.Fingerprint.Signature,
+				FastOpen:     e.FastOpen.Status,
+			}
+		}
+
+	case TraceAborted:
+		r.Status = TraceAborted
+
+	case TraceFailed:
+		r.Status = TraceFailed
+		if e.Err != nil {
+			r.Error = e.Err.Error()
+		}
+
+	case TraceComplete:
+		r.Duration = e.Time
+		r.Finished = r.Started.Add(e.Time)
+		for _, c := range FindOptionChanges(r.Events()) {
+			for i := range r.Hops {
+				if h := &r.Hops[i]; h.TTL == c.Hop {
+					h.OptionChanges = append(h.OptionChanges, OptionChange{
+						Type:      c.Type.String(),
+						Change:    c.TCPOptionChange.String(),
+						PrevHop:   c.PrevHop,
+						Responder: c.Responder.IP,
+					})
+				}
+			}
+		}
+	}
+}
+
+// TraceOptions returns the options the trace was run with, as far as the
+// result records them.
+func (r *Result) TraceOptions() Options {
+	opts := DefaultOptions
+	opts.Target = r.Target
+	opts.Port = r.Port
+	if r.Addr != nil {
+		opts.Addr = &net.IPAddr{IP: r.Addr}
+	}
+	opts.AddrIndex, opts.AddrCount = r.AddrIndex, r.AddrCount
+	if o := r.Options; o != nil {
+		opts.StartHop, opts.EndHop = o.StartHop, o.EndHop
+		opts.Queries, opts.Timeout = o.Queries, o.Timeout
+		opts.ProbeType = o.ProbeType
+		opts.TCPOptions, _ = ParseTCPOptionSpec(o.TCPOptions)
+		opts.Source = o.Source
+		opts.FastOpen = o.FastOpen
+	}
+	return opts
+}
+
+// Events reconstructs the events of the trace, so that it can be written
+// again by any output writer.
+func (r *Result) Events() []TraceEvent {
+	events := []TraceEvent{{
+		Type:   TraceStarted,
+		Addr:   net.IPAddr{IP: r.Addr},
+		Source: net.IPAddr{IP: r.LocalAddr},
+		Sent:   r.Started,
+	}}
+
+	for _, h := range r.Hops {
+		for _, p := range h.Probes {
+			e := TraceEvent{
+				Type:        p.Outcome,
+				Hop:         h.TTL,
+				Query:       p.Query,
+				Addr:        net.IPAddr{IP: p.Addr},
+				Time:        p.RTT,
+				ReplyTTL:    p.ReplyTTL,
+				ReverseHops: p.ReverseHops,
+				ICMPType:    p.ICMPType,
+				ICMPCode:    p.ICMPCode,
+			}
+			if p.Sent != 0 {
+				e.Sent = r.Started.Add(p.Sent)
+			}
+			if p.SentOptions != nil {
+				e.SentOptions, _ = ParseTCPOptions(*p.SentOptions)
+			}
+			if p.ReplyOptions != nil {
+				e.ReplyOptions, _ = ParseTCPOptions(*p.ReplyOptions)
+				e.ReplyOptionsSeen = true
+			}
+			if d := r.Destination; d != nil && d.Hop == h.TTL && d.Outcome == p.Outcome && d.Addr.Equal(p.Addr) {
+				e.Responder = ResponderInfo{Class: d.Responder, Hop: d.ResponderHop}
+				e.Fingerprint = StackFingerprint{Label: d.Fingerprint, Signature: d.Signature}
+				e.FastOpen.Status = d.FastOpen
+			}
+			events = append(events, e)
+		}
+	}
+
+	switch r.Status {
+	case TraceAborted:
+		events = append(events, TraceEvent{Type: TraceAborted, Time: r.Duration})
+	case TraceFailed:
+		events = append(events, TraceEvent{Type: TraceFailed, Err: errors.New(r.Error), Time: r.Duration})
+	}
+	return append(events, TraceEvent{Type: TraceComplete, Time: r.Duration})
+}
+
+// LookupNames fills in the names of the destination and every responder using
+// lookup, which is normally ReverseLookup. each address is only looked up once.
+func (r *Result) LookupNames(lookup func(ip net.IPAddr) (string, error)) {
+	names := map[string]string{}
+	name := func(ip net.IP) string {
+		if ip == nil {
+			return ""
+		}
+		if n, ok := names[ip.String()]; ok {
+			return n
+		}
+		n, _ := lookup(net.IPAddr{IP: ip})
+		names[ip.String()] = n
+		return n
+	}
+
+	r.AddrName = name(r.Addr)
+	for i := range r.Hops {
+		for j := range r.Hops[i].Probes {
+			r.Hops[i].Probes[j].Name = name(r.Hops[i].Probes[j].Addr)
+		}
+	}
+	if r.Destination != nil {
+		r.Destination.Name = name(r.Destination.Addr)
+	}
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package tracetcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xcafed00d/assert"
+)
+
+func testTraceEvents() []TraceEvent {
+	r1 := net.IPAddr{IP: net.IPv4(10, 0, 0, 1).To4()}
+	r2 := net.IPAddr{IP: net.IPv4(10, 0, 0, 2).To4()}
+	dest := net.IPAddr{IP: net.IPv4(10, 0, 0, 9).To4()}
+
+	return []TraceEvent{
+		{Type: TraceStarted, Addr: dest},
+		{Type: TTLExpired, Hop: 1, Query: 0, Addr: r1, Time: 1 * time.Millisecond, ReplyTTL: 64, ReverseHops: 1},
+		{Type: TTLExpired, Hop: 1, Query: 1, Addr: r2, Time: 3 * time.Millisecond},
+		{Type: TimedOut, Hop: 1, Query: 2, Time: time.Second},
+		{Type: TimedOut, Hop: 2, Query: 0, Time: time.Second},
+		{Type: Connected, Hop: 3, Query: 0, Addr: dest, Time: 5 * time.Millisecond,
+			Responder:   ResponderInfo{Class: ResponderDestination},
+			Fingerprint: StackFingerprint{Label: "s:unix:Linux:3.x", Signature: "4:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df:0"}},
+		{Type: TraceComplete, Time: 2 * time.Second},
+	}
+}
+
+func testOptions() Options {
+	opts := DefaultOptions
+	opts.Target = "test.example.com"
+	return opts
+}
+
+func TestResultFromEvents(t *testing.T) {
+	assert := assert.Make(t)
+
+	result := NewResult(testOptions())
+	for _, e := range testTraceEvents() {
+		result.Add(e)
+	}
+
+	assert(result.Status, result.Reached).Equal(Connected, true)
+	assert(result.Addr.String(), result.Duration).Equal("10.0.0.9", 2*time.Second)
+	assert(len(result.Hops)).Equal(3)
+
+	hop := result.Hops[0]
+	assert(hop.TTL, hop.Sent, hop.Received).Equal(1, 3, 2)
+	assert(hop.RTTMin, hop.RTTAvg, hop.RTTMax).Equal(time.Millisecond, 2*time.Millisecond, 3*time.Millisecond)
+	assert(hop.Loss > 0.33 && hop.Loss < 0.34).IsTrue()
+	assert(len(hop.Responders)).Equal(2)
+
+	hop = result.Hops[1]
+	assert(hop.Received, hop.Loss, hop.RTTAvg).Equal(0, 1.0, time.Duration(0))
+	assert(len(hop.Responders)).Equal(0)
+
+	assert(result.Destination.Hop, result.Destination.Outcome).Equal(3, Connected)
+	assert(result.Destination.Responder, result.Destination.Fingerprint).Equal(ResponderDestination, "s:unix:Linux:3.x")
+
+	failed := NewResult(DefaultOptions)
+	failed.Add(TraceEvent{Type: TraceFailed, Err: errors.New("no route to host")})
+	assert(failed.Status, failed.Reached, failed.Error).Equal(TraceFailed, false, "no route to host")
+}
+
+func TestResultAddrChoice(t *testing.T) {
+	assert := assert.Make(t)
+
+	opts := testOptions()
+	opts.AddrIndex, opts.AddrCount = 2, 3
+	result := NewResult(opts)
+	for _, e := range testTraceEvents() {
+		result.Add(e)
+	}
+	assert(result.AddrFamily, result.AddrIndex, result.AddrCount).Equal("ipv4", 2, 3)
+
+	traced := result.TraceOptions()
+	assert(traced.AddrIndex, traced.AddrCount).Equal(2, 3)
+
+	var buf bytes.Buffer
+	w := &StdTraceWriter{}
+	opts.Addr = &net.IPAddr{IP: net.IPv4(10, 0, 0, 9)}
+	assert(w.Init(OutputConfig{Options: opts, NoLookups: true, Out: &buf})).NoError()
+	assert(w.Event(testTraceEvents()[0])).NoError()
+	assert(buf.String()).Equal("test.example.com has 3 addresses, tracing ipv4 address 2 of 3:\n" +
+		"Tracing route to 10.0.0.9 on port 80 over a maximum of 30 hops:\n")
+
+	assert(AddrFamily(net.ParseIP("2001:db8::1"))).Equal("ipv6")
+}
+
+func TestResultJSONRoundTrip(t *testing.T) {
+	assert := assert.Make(t)
+
+	result := NewResult(testOptions())
+	for _, e := range testTraceEvents() {
+		result.Add(e)
+	}
+
+	var buf bytes.Buffer
+	assert(json.NewEncoder(&buf).Encode(result)).NoError()
+	encoded := buf.String()
+	assert(strings.Contains(buf.String(), `"outcome":"TTLExpired"`)).IsTrue()
+	assert(strings.Contains(buf.String(), `"responders":["10.0.0.1","10.0.0.2"]`)).IsTrue()
+	assert(strings.Contains(buf.String(), `"responder":"destination"`)).IsTrue()
+
+	decoded, err := ReadResult(&buf)
+	assert(err).NoError()
+	assert(decoded.Destination.Addr.Equal(result.Destination.Addr)).IsTrue()
+	reencoded, err := json.Marshal(decoded)
+	assert(err).NoError()
+	assert(string(reencoded) + "\n").Equal(encoded)
+
+	assert(decoded.Started.Equal(result.Started)).IsTrue()
+
+	assert(ReadResult(strings.NewReader(`{"version": 99}`))).HasError()
+	assert(ReadResult(strings.NewReader(`{"version": 1, "status": "Bogus"}`))).HasError()
+}
@@ -0,0 +1,54 @@
+package tracetcp
+
+import (
+	"net"
+)
+
+// resultWriter collects the events of a trace into a Result and hands it to
+// render once, when the trace completes or the writer is closed. it is
+// embedded by writers that need the whole trace before writing anything.
+type resultWriter struct {
+	config  OutputConfig
+	result  *Result
+	written bool
+	lookup  func(ip net.IPAddr) (string, error)
+	render  func(r *Result) error
+}
+
+func (w *resultWriter) Init(config OutputConfig) error {
+	w.config = config
+	w.result = NewResult(config.Options)
+	w.written = false
+	if w.lookup == nil {
+		w.lookup = ReverseLookup
+	}
+	return nil
+}
+
+func (w *resultWriter) Event(e TraceEvent) error {
+	w.result.Add(e)
+	if e.Type == TraceComplete {
+		return w.write()
+	}
+	return nil
+}
+
+func (w *resultWriter) Close() error {
+	if w.result == nil || w.written {
+		return nil
+	}
+	// the trace was abandoned before it completed
+	if !w.result.Reached && w.result.Status != TraceFailed {
+		w.result.Add(TraceEvent{Type: TraceAborted})
+	}
+	w.result.Add(TraceEvent{Type: TraceComplete, Time: timeNow().Sub(w.result.Started)})
+	return w.write()
+}
+
+func (w *resultWriter) write() error {
+	w.written = true
+	if !w.config.NoLookups {
+		w.result.LookupNames(w.lookup)
+	}
+	return w.render(w.result)
+}
@@ -0,0 +1,42 @@
+package tracetcp
+
+import (
+	"context"
+)
+
+// Run traces the route described by opts and waits for it to finish. if ctx
+// is cancelled the trace is aborted and the partial result is returned along
+// with ctx.Err().
+func Run(ctx context.Context, opts Options) (*Result, error) {
+	if err := opts.resolve(); err != nil {
+		return nil, err
+	}
+	t := NewTrace()
+	if err := t.Start(opts); err != nil {
+		return nil, err
+	}
+
+	result := NewResult(opts)
+	var traceErr error
+	cancelled := ctx.Done()
+	for {
+		select {
+		case <-cancelled:
+			t.AbortTrace()
+			cancelled = nil
+
+		case ev := <-t.Events:
+			result.Add(ev)
+			if ev.Type == TraceFailed {
+				traceErr = ev.Err
+			}
+			if ev.Type != TraceComplete {
+				continue
+			}
+			if result.Status == TraceAborted {
+				return result, ctx.Err()
+			}
+			return result, traceErr
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package tracetcp
+
+import (
+	"encoding/json"
+	"net"
+)
+
+// ScamperTraceWriter writes the trace in the JSON form of a scamper trace
+// object, as printed by sc_warts2json, one object per line. as in warts, only
+// the probes that were answered are listed.
+type ScamperTraceWriter struct {
+	resultWriter
+}
+
+func (w *ScamperTraceWriter) Init(config OutputConfig) error {
+	w.render = w.writeScamper
+	return w.resultWriter.Init(config)
+}
+
+type scamperTime struct {
+	Sec   int64  `json:"sec"`
+	Usec  int    `json:"usec"`
+	FTime string `json:"ftime"`
+}
+
+type scamperHop struct {
+	Addr      net.IP     `json:"addr"`
+	ProbeTTL  int        `json:"probe_ttl"`
+	ProbeID   int        `json:"probe_id"`
+	ProbeSize int        `json:"probe_size"`
+	RTT       jsonMillis `json:"rtt"`
+	ReplyTTL  int        `json:"reply_ttl,omitempty"`
+	ICMPType  *int       `json:"icmp_type,omitempty"`
+	ICMPCode  *int       `json:"icmp_code,omitempty"`
+	TCPFlags  *int       `json:"tcp_flags,omitempty"`
+}
+
+type scamperTrace struct {
+	Type       string       `json:"type"`
+	Version    string       `json:"version"`
+	UserID     int          `json:"userid"`
+	Method     string       `json:"method"`
+	Src        net.IP       `json:"src,omitempty"`
+	Dst        net.IP       `json:"dst"`
+	DPort      int          `json:"dport"`
+	StopReason string       `json:"stop_reason"`
+	StopData   int          `json:"stop_data"`
+	Start      scamperTime  `json:"start"`
+	HopCount   int          `json:"hop_count"`
+	Attempts   int          `json:"attempts"`
+	HopLimit   int          `json:"hoplimit"`
+	FirstHop   int          `json:"firsthop"`
+	Wait       int          `json:"wait"`
+	WaitProbe  int          `json:"wait_probe"`
+	TOS        int          `json:"tos"`
+	ProbeSize  int          `json:"probe_size"`
+	ProbeCount int          `json:"probe_count"`
+	Hops       []scamperHop `json:"hops"`
+}
+
+// tcpReplyFlags returns the flags of the TCP segment a destination answered
+// a probe with.
+func tcpReplyFlags(outcome TraceEventType) int {
+	switch outcome {
+	case Connected:
+		return tcpFlagSYN | tcpFlagACK
+	case RemoteClosed:
+		return tcpFlagRST | tcpFlagACK
+	}
+	return 0
+}
+
+// scamperStopReason returns the reason scamper would give for a trace
+// stopping, and the ICMP code for an unreachable.
+func scamperStopReason(r *Result) (string, int) {
+	switch r.Status {
+	case Connected, RemoteClosed:
+		return "COMPLETED", 0
+	case Unreachable:
+		for _, h := range r.Hops {
+			for _, p := range h.Probes {
+				if p.Outcome == Unreachable {
+					return "UNREACH", p.ICMPCode
+				}
+			}
+		}
+		return "UNREACH", 0
+	case TraceAborted:
+		return "HALTED", 0
+	case TraceFailed:
+		return "ERROR", 0
+	}
+	return "HOPLIMIT", 0
+}
+
+func (w *ScamperTraceWriter) writeScamper(r *Result) error {
+	size := w.config.probeSize()
+	st := scamperTrace{
+		Type:    "trace",
+		Version: "0.1",
+		Method:  "tcp",
+		Src:     r.LocalAddr,
+		Dst:     r.Addr,
+		DPort:   r.Port,
+		Start: scamperTime{
+			Sec:   r.Started.Unix(),
+			Usec:  r.Started.Nanosecond() / 1000,
+			FTime: r.Started.Format("2006-01-02 15:04:05"),
+		},
+		Attempts:  w.config.Queries,
+		HopLimit:  w.config.EndHop,
+		FirstHop:  w.config.StartHop,
+		Wait:      int(w.config.Timeout.Seconds()),
+		ProbeSize: size,
+		Hops:      []scamperHop{},
+	}
+	st.StopReason, st.StopData = scamperStopReason(r)
+
+	for _, h := range r.Hops {
+		st.HopCount = h.TTL
+		st.ProbeCount += h.Sent
+		for _, p := range h.Probes {
+			if !p.Replied() {
+				continue
+			}
+			hop := scamperHop{
+				Addr:      p.Addr,
+				ProbeTTL:  h.TTL,
+				ProbeID:   p.Query + 1,
+				ProbeSize: size,
+				RTT:       jsonMillis(millis(p.RTT)),
+				ReplyTTL:  p.ReplyTTL,
+			}
+			if flags := tcpReplyFlags(p.Outcome); flags != 0 {
+				hop.TCPFlags = &flags
+			} else {
+				icmpType, icmpCode := p.ICMPType, p.ICMPCode
+				if p.Outcome == TTLExpired && icmpType == 0 {
+					// results from before the ICMP type was recorded
+					icmpType = icmpTypeTimeExceeded
+				}
+				hop.ICMPType, hop.ICMPCode = &icmpType, &icmpCode
+			}
+			st.Hops = append(st.Hops, hop)
+		}
+	}
+
+	return json.NewEncoder(w.config.Out).Encode(st)
+}
@@ -71,3 +71,20 @@ func waitWithTimeout(socket int, timeout time.Duration) (state SocketState, err
 	}
 	return
 }
+
+// receivers wake this often to check whether they have been stopped
+const receivePollInterval = 100 * time.Millisecond
+
+func setReceiveTimeout(socket int) error {
+	tv := MakeTimeval(receivePollInterval)
+	return syscall.SetsockoptTimeval(socket, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+}
+
+func isClosed(done chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
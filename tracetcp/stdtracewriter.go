@@ -8,6 +8,9 @@ import (
 )
 
 type StdTraceWriter struct {
+	target        string
+	addrIndex     int
+	addrCount     int
 	port          int
 	hopsFrom      int
 	hopsTo        int
@@ -16,16 +19,29 @@ type StdTraceWriter struct {
 	out           io.Writer
 	currentHop    int
 	currentAddr   *net.IPAddr
+	replyEvents   []TraceEvent
+	lookup        func(ip net.IPAddr) (string, error)
 }
 
-func (w *StdTraceWriter) Init(port int, hopsFrom, hopsTo, queriesPerHop int, noLookups bool, out io.Writer) {
-	w.port = port
-	w.hopsFrom = hopsFrom
-	w.hopsTo = hopsTo
-	w.queriesPerHop = queriesPerHop
-	w.noLooups = noLookups
-	w.out = out
+func (w *StdTraceWriter) Init(config OutputConfig) error {
+	w.target = config.Target
+	w.addrIndex, w.addrCount = config.AddrIndex, config.AddrCount
+	w.port = config.Port
+	w.hopsFrom = config.StartHop
+	w.hopsTo = config.EndHop
+	w.queriesPerHop = config.Queries
+	w.noLooups = config.NoLookups
+	w.out = config.Out
 	w.currentHop = 0
+	w.replyEvents = nil
+	if w.lookup == nil {
+		w.lookup = ReverseLookup
+	}
+	return nil
+}
+
+func (w *StdTraceWriter) Close() error {
+	return nil
 }
 
 func (w *StdTraceWriter) Event(e TraceEvent) error {
@@ -43,9 +59,13 @@ func (w *StdTraceWriter) Event(e TraceEvent) error {
 
 	switch e.Type {
 	case TraceStarted:
+		if w.addrCount > 1 {
+			fmt.Fprintf(w.out, "%v has %v addresses, tracing %v address %v of %v:\n",
+				w.target, w.addrCount, AddrFamily(e.Addr.IP), w.addrIndex, w.addrCount)
+		}
 		var revhost string
 		if !w.noLooups {
-			revhost, _ = ReverseLookup(e.Addr)
+			revhost, _ = w.lookup(e.Addr)
 		}
 		if revhost != "" {
 			fmt.Fprintf(w.out, "Tracing route to %v (%v) on port %v over a maximum of %v hops:\n",
@@ -60,15 +80,44 @@ func (w *StdTraceWriter) Event(e TraceEvent) error {
 	case TTLExpired:
 		w.currentAddr = &e.Addr
 		fmt.Fprintf(w.out, "%8v", (e.Time/time.Millisecond)*time.Millisecond)
+		w.replyEvents = append(w.replyEvents, e)
 	case Connected:
+		w.replyEvents = append(w.replyEvents, e)
 		fmt.Fprintf(w.out, "Connected to %v on port %v\n", e.Addr.String(), w.port)
+		if e.ReplyOptionsSeen {
+			fmt.Fprintf(w.out, "   SYN-ACK options: %v\n", e.ReplyOptions)
+		}
+		if e.FastOpen.Status != FastOpenNotTested {
+			fmt.Fprintf(w.out, "   TCP Fast Open: %v\n", e.FastOpen)
+		}
+		w.writeResponder(e)
+	case Unreachable:
+		fmt.Fprintf(w.out, "Destination unreachable %v from %v\n", UnreachableAnnotation(e.ICMPCode), e.Addr.String())
 	case RemoteClosed:
+		w.replyEvents = append(w.replyEvents, e)
 		fmt.Fprintf(w.out, "Port %v closed at %v\n", w.port, e.Addr.String())
+		w.writeResponder(e)
+	case TraceComplete:
+		if changes := FindOptionChanges(w.replyEvents); len(changes) > 0 {
+			fmt.Fprintf(w.out, "\nTCP option changes:\n")
+			for _, c := range changes {
+				fmt.Fprintf(w.out, "   %v\n", c)
+			}
+		}
+		if asym := FindAsymmetricHops(w.replyEvents, DefaultAsymmetryThreshold); len(asym) > 0 {
+			fmt.Fprintf(w.out, "\nPossible asymmetric routing:\n")
+			for _, a := range asym {
+				fmt.Fprintf(w.out, "   %v\n", a)
+			}
+		}
 	}
 
 	if e.Query == w.queriesPerHop-1 && w.currentAddr != nil {
-		name, _ := ReverseLookup(*w.currentAddr)
-		if name == "" || w.noLooups {
+		var name string
+		if !w.noLooups {
+			name, _ = w.lookup(*w.currentAddr)
+		}
+		if name == "" {
 			fmt.Fprintf(w.out, "\t%v", w.currentAddr.String())
 		} else {
 			fmt.Fprintf(w.out, "\t%v (%v)", name, w.currentAddr.String())
@@ -77,3 +126,12 @@ func (w *StdTraceWriter) Event(e TraceEvent) error {
 
 	return nil
 }
+
+func (w *StdTraceWriter) writeResponder(e TraceEvent) {
+	if e.Responder.Class != ResponderUnknown {
+		fmt.Fprintf(w.out, "   Responder: %v (reply TTL %d)\n", e.Responder, e.ReplyTTL)
+	}
+	if e.Fingerprint.Signature != "" {
+		fmt.Fprintf(w.out, "   Fingerprint: %v\n", e.Fingerprint)
+	}
+}
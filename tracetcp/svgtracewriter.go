@@ -0,0 +1,150 @@
+package tracetcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// SVGTraceWriter draws a chart of the RTT at each hop as a standalone SVG
+// document: a bar from the fastest to the slowest reply with a mark at the
+// average, a dot for every reply and a cross for every probe that timed out.
+type SVGTraceWriter struct {
+	resultWriter
+}
+
+const (
+	svgWidth      = 960
+	svgLabelWidth = 300
+	svgLossWidth  = 110
+	svgHeaderH    = 64
+	svgRowH       = 26
+	svgFooterH    = 30
+	svgChartLeft  = svgLabelWidth
+	svgChartWidth = svgWidth - svgLabelWidth - svgLossWidth - 20
+)
+
+func (w *SVGTraceWriter) Init(config OutputConfig) error {
+	w.render = w.writeSVG
+	return w.resultWriter.Init(config)
+}
+
+func svgEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// niceScale returns a tick interval of 1, 2 or 5 times a power of ten giving
+// about five ticks up to max, and the end of the axis.
+func niceScale(max float64) (step, end float64) {
+	if max <= 0 {
+		return 1, 5
+	}
+	raw := max / 5
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		step = m * mag
+		if step >= raw {
+			break
+		}
+	}
+	return step, math.Ceil(max/step) * step
+}
+
+func (w *SVGTraceWriter) writeSVG(r *Result) error {
+	var maxRTT time.Duration
+	for _, h := range r.Hops {
+		if h.RTTMax > maxRTT {
+			maxRTT = h.RTTMax
+		}
+	}
+	step, end := niceScale(millis(maxRTT))
+	x := func(d time.Duration) float64 {
+		return svgChartLeft + millis(d)/end*svgChartWidth
+	}
+
+	height := svgHeaderH + len(r.Hops)*svgRowH + svgFooterH
+	out := bufio.NewWriter(w.config.Out)
+
+	fmt.Fprintf(out, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(out, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="12">`+"\n",
+		svgWidth, height, svgWidth, height)
+	fmt.Fprintf(out, `<rect width="100%%" height="100%%" fill="white"/>`+"\n")
+
+	dest := r.Addr.String()
+	if r.AddrName != "" {
+		dest = fmt.Sprintf("%v (%v)", r.AddrName, r.Addr)
+	}
+	fmt.Fprintf(out, `<text x="10" y="20" font-size="15" font-weight="bold">%v</text>`+"\n",
+		svgEscape(fmt.Sprintf("tracetcp to %v port %v: %v", dest, r.Port, r.Status)))
+	if r.Error != "" {
+		fmt.Fprintf(out, `<text x="10" y="38" fill="#c00">%v</text>`+"\n", svgEscape(r.Error))
+	}
+
+	// axis and grid
+	top, bottom := svgHeaderH-8, svgHeaderH+len(r.Hops)*svgRowH
+	decimals := 0
+	if step < 1 {
+		decimals = int(-math.Floor(math.Log10(step)))
+	}
+	for i := 0; float64(i)*step <= end+step/2; i++ {
+		v := float64(i) * step
+		gx := svgChartLeft + v/end*svgChartWidth
+		fmt.Fprintf(out, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" stroke="#ddd"/>`+"\n", gx, top, gx, bottom)
+		fmt.Fprintf(out, `<text x="%.1f" y="%d" text-anchor="middle" fill="#666">%.*fms</text>`+"\n", gx, top-4, decimals, v)
+	}
+	fmt.Fprintf(out, `<text x="%d" y="%d" text-anchor="end" fill="#666">loss</text>`+"\n", svgWidth-10, top-4)
+
+	for i, h := range r.Hops {
+		y := svgHeaderH + i*svgRowH
+		mid := float64(y) + svgRowH/2
+		if i%2 == 1 {
+			fmt.Fprintf(out, `<rect x="0" y="%d" width="%d" height="%d" fill="#f6f6f6"/>`+"\n", y, svgWidth, svgRowH)
+		}
+
+		label := strings.Join(hopHosts(h), ", ")
+		if label == "" {
+			label = "*"
+		}
+		if runes := []rune(label); len(runes) > 44 {
+			label = string(runes[:43]) + "…"
+		}
+		fmt.Fprintf(out, `<text x="10" y="%.1f" dominant-baseline="middle">%d</text>`+"\n", mid, h.TTL)
+		fmt.Fprintf(out, `<text x="40" y="%.1f" dominant-baseline="middle">%v</text>`+"\n", mid, svgEscape(label))
+
+		if h.Received > 0 {
+			fmt.Fprintf(out, `<rect x="%.1f" y="%.1f" width="%.1f" height="10" fill="#9cc3e6" stroke="#2f6ea8"/>`+"\n",
+				x(h.RTTMin), mid-5, math.Max(x(h.RTTMax)-x(h.RTTMin), 1))
+			fmt.Fprintf(out, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#1a3f63" stroke-width="2"/>`+"\n",
+				x(h.RTTAvg), mid-8, x(h.RTTAvg), mid+8)
+		}
+		lost := 0
+		for _, p := range h.Probes {
+			if p.Replied() {
+				fmt.Fprintf(out, `<circle cx="%.1f" cy="%.1f" r="2.5" fill="#1a3f63"><title>%v</title></circle>`+"\n",
+					x(p.RTT), mid, svgEscape(fmt.Sprintf("query %d: %.3fms from %v", p.Query, millis(p.RTT), p.Addr)))
+				continue
+			}
+			cx := float64(svgWidth - svgLossWidth + 4 + lost*12)
+			fmt.Fprintf(out, `<path d="M%.1f %.1f l8 8 m0 -8 l-8 8" stroke="#c00" stroke-width="2"><title>query %d timed out</title></path>`+"\n",
+				cx, mid-4, p.Query)
+			lost++
+		}
+		fmt.Fprintf(out, `<text x="%d" y="%.1f" text-anchor="end" dominant-baseline="middle">%.0f%%</text>`+"\n",
+			svgWidth-10, mid, h.Loss*100)
+	}
+
+	fmt.Fprintf(out, `<text x="10" y="%d" fill="#666">%v</text>`+"\n", height-10,
+		svgEscape(fmt.Sprintf("%v, started %v, took %v", r.Tool, r.Started.UTC().Format(time.RFC3339), r.Duration.Round(time.Millisecond))))
+	fmt.Fprintf(out, "</svg>\n")
+	return out.Flush()
+}
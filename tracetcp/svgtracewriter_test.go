@@ -0,0 +1,34 @@
+package tracetcp
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/0xcafed00d/assert"
+)
+
+func TestNiceScale(t *testing.T) {
+	assert := assert.Make(t)
+
+	assert(niceScale(0)).Equal(1.0, 5.0)
+	assert(niceScale(5)).Equal(1.0, 5.0)
+	assert(niceScale(42)).Equal(10.0, 50.0)
+	assert(niceScale(130)).Equal(50.0, 150.0)
+	assert(niceScale(0.9)).Equal(0.2, 1.0)
+}
+
+func TestSVGTraceWriterGolden(t *testing.T) {
+	assert := assert.Make(t)
+	defer fixedClock()()
+
+	w := &SVGTraceWriter{}
+	w.lookup = testLookup
+	output := writeGraphTrace(w, testTraceEvents())
+	checkGolden(t, "connected.svg", output)
+
+	// must be well formed XML
+	var doc struct {
+		XMLName xml.Name `xml:"svg"`
+	}
+	assert(xml.Unmarshal(output, &doc)).NoError()
+}
@@ -0,0 +1,211 @@
+package tracetcp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"log"
+	"net"
+	"syscall"
+	"time"
+)
+
+// trySYN sends a hand built SYN on a raw socket so that the exact option set
+// is under our control, then waits for the matching ICMP or TCP reply. payload
+// is only sent along with a fast open cookie.
+func trySYN(dest net.IPAddr, port, ttl, query int, timeout time.Duration, source net.IPAddr, opts TCPOptions, payload []byte,
+	icmpChan chan icmpEvent, tcpChan chan tcpEvent) (result connectEvent, icmpev icmpEvent, tcpev tcpEvent) {
+
+	log.Printf("try SYN dest: %v port: %v ttl: %v query: %v timeout: %v options: %v",
+		dest, port, ttl, query, timeout, opts)
+
+	event := connectEvent{
+		remoteAddr: dest,
+		remotePort: port,
+		ttl:        ttl,
+		query:      query,
+	}
+
+	local := source
+	if local.IP == nil {
+		var err error
+		local, err = localAddrFor(dest)
+		if err != nil {
+			result = makeErrorEvent(&event, err)
+			return
+		}
+	}
+	event.localAddr = local
+
+	// bind a normal socket to reserve the local port for the duration of the probe
+	reserve, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, syscall.IPPROTO_TCP)
+	if err != nil {
+		result = makeErrorEvent(&event, err)
+		return
+	}
+	defer syscall.Close(reserve)
+
+	err = syscall.Bind(reserve, ToSockaddrInet4(local, 0))
+	if err != nil {
+		result = makeErrorEvent(&event, err)
+		return
+	}
+
+	bound, err := syscall.Getsockname(reserve)
+	if err != nil {
+		result = makeErrorEvent(&event, err)
+		return
+	}
+	_, event.localPort, _ = ToIPAddrAndPort(bound)
+
+	sock, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_TCP)
+	if err != nil {
+		result = makeErrorEvent(&event, err)
+		return
+	}
+	defer syscall.Close(sock)
+
+	err = syscall.SetsockoptInt(sock, syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+	if err != nil {
+		result = makeErrorEvent(&event, err)
+		return
+	}
+
+	event.sequence = randomSequence()
+	event.sentOptions = stampTCPOptions(opts)
+	segment, err := buildSYN(local, dest, event.localPort, port, event.sequence, event.sentOptions, payload)
+	if err != nil {
+		result = makeErrorEvent(&event, err)
+		return
+	}
+
+	err = syscall.Sendto(sock, segment, 0, ToSockaddrInet4(dest, 0))
+	if err != nil {
+		result = makeErrorEvent(&event, err)
+		return
+	}
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case iev := <-icmpChan:
+			if iev.evtype == icmpError {
+				icmpev = iev
+				result = makeEvent(&event, connectUnreachable)
+				return
+			}
+			if iev.localPort == event.localPort && iev.quotedTCP.Sequence == event.sequence {
+				icmpev = iev
+				result = makeEvent(&event, connectUnreachable)
+				return
+			}
+
+		case tev := <-tcpChan:
+			if tev.err != nil {
+				tcpev = tev
+				result = makeErrorEvent(&event, tev.err)
+				return
+			}
+			if tev.localPort != event.localPort || tev.remotePort != port || !tev.remoteAddr.IP.Equal(dest.IP) {
+				break
+			}
+			tcpev = tev
+			if tev.isReset() {
+				result = makeEvent(&event, connectRefused)
+			} else if tev.tcp.AckNum == event.sequence+1 || tev.tcp.AckNum == event.sequence+1+uint32(len(payload)) {
+				result = makeEvent(&event, connectConnected)
+			} else {
+				break
+			}
+			return
+
+		case <-deadline:
+			result = makeEvent(&event, connectTimedOut)
+			return
+		}
+	}
+}
+
+// randomSequence returns an initial sequence number that can not be guessed,
+// so the probes of traces sharing a listener are told apart.
+func randomSequence() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return uint32(time.Now().UnixNano())
+	}
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// stampTCPOptions returns a copy of opts with the timestamp value filled in.
+func stampTCPOptions(opts TCPOptions) TCPOptions {
+	stamped := make(TCPOptions, len(opts))
+	for i, o := range opts {
+		stamped[i] = TCPOption{Kind: o.Kind, Data: append([]byte{}, o.Data...)}
+		if o.Kind == TCPOptTimestamps && len(o.Data) == 8 {
+			binary.BigEndian.PutUint32(stamped[i].Data, uint32(time.Now().UnixNano()/int64(time.Millisecond)))
+		}
+	}
+	return stamped
+}
+
+func buildSYN(src, dest net.IPAddr, srcPort, destPort int, seq uint32, opts TCPOptions, payload []byte) ([]byte, error) {
+	options, err := opts.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	hdrlen := tcpHeaderLen + len(options)
+	segment := make([]byte, hdrlen+len(payload))
+	binary.BigEndian.PutUint16(segment[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(segment[2:], uint16(destPort))
+	binary.BigEndian.PutUint32(segment[4:], seq)
+	segment[12] = byte(hdrlen/4) << 4
+	segment[13] = tcpFlagSYN
+	binary.BigEndian.PutUint16(segment[14:], 64240)
+	copy(segment[tcpHeaderLen:], options)
+	copy(segment[hdrlen:], payload)
+
+	binary.BigEndian.PutUint16(segment[16:], tcpChecksum(src, dest, segment))
+	return segment, nil
+}
+
+func tcpChecksum(src, dest net.IPAddr, segment []byte) uint16 {
+	pseudo := make([]byte, 12, 12+len(segment)+1)
+	copy(pseudo[0:], src.IP.To4())
+	copy(pseudo[4:], dest.IP.To4())
+	pseudo[9] = syscall.IPPROTO_TCP
+	binary.BigEndian.PutUint16(pseudo[10:], uint16(len(segment)))
+	data := append(pseudo, segment...)
+	if len(data)%2 != 0 {
+		data = append(data, 0)
+	}
+
+	var sum uint32
+	for i := 0; i < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+// localAddrFor returns the local address the kernel would use to reach dest.
+func localAddrFor(dest net.IPAddr) (net.IPAddr, error) {
+	sock, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
+	if err != nil {
+		return net.IPAddr{}, err
+	}
+	defer syscall.Close(sock)
+
+	err = syscall.Connect(sock, ToSockaddrInet4(dest, 9))
+	if err != nil {
+		return net.IPAddr{}, err
+	}
+
+	local, err := syscall.Getsockname(sock)
+	if err != nil {
+		return net.IPAddr{}, err
+	}
+	addr, _, err := ToIPAddrAndPort(local)
+	return addr, err
+}
@@ -0,0 +1,247 @@
+package tracetcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyslogTraceWriter sends a message for each hop and a summary of the trace
+// to the local syslog daemon in RFC 5424 format with the details as
+// structured data, or to systemd-journald with the details as journal fields
+// if Journal is set.
+type SyslogTraceWriter struct {
+	resultWriter
+	Journal bool
+
+	// private enterprise number the structured data IDs are qualified with,
+	// DefaultSyslogEnterprise if zero
+	Enterprise int
+
+	dial     func() (io.WriteCloser, error)
+	hostname string
+	pid      int
+}
+
+// sockets the local syslog daemon may be listening on
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+const journalSocket = "/run/systemd/journal/socket"
+
+// syslog severities, sent with the user facility
+const (
+	syslogFacilityUser = 1
+	syslogError        = 3
+	syslogWarning      = 4
+	syslogNotice       = 5
+	syslogInfo         = 6
+)
+
+// DefaultSyslogEnterprise is the private enterprise number set aside for
+// documentation by RFC 5612, used when none is given. tracetcp has no number
+// of its own registered with IANA, so a site that keeps its logs should give
+// its own as -o syslog=PEN.
+const DefaultSyslogEnterprise = 32473
+
+func (w *SyslogTraceWriter) Usage() string {
+	if w.Journal {
+		return "journald"
+	}
+	return "syslog[=PEN]"
+}
+
+// Configure takes the private enterprise number of the structured data IDs.
+// journald output takes no argument.
+func (w *SyslogTraceWriter) Configure(sep byte, arg string) error {
+	if sep == 0 {
+		return nil
+	}
+	if w.Journal {
+		return fmt.Errorf("journald output does not take an argument")
+	}
+	pen, err := strconv.Atoi(arg)
+	if err != nil || pen <= 0 {
+		return fmt.Errorf("Invalid private enterprise number: %v", arg)
+	}
+	w.Enterprise = pen
+	return nil
+}
+
+func (w *SyslogTraceWriter) Init(config OutputConfig) error {
+	w.render = w.writeLog
+	if w.dial == nil {
+		w.dial = w.dialSocket
+	}
+	if w.hostname == "" {
+		w.hostname, _ = os.Hostname()
+	}
+	if w.pid == 0 {
+		w.pid = os.Getpid()
+	}
+	if w.Enterprise == 0 {
+		w.Enterprise = DefaultSyslogEnterprise
+	}
+	return w.resultWriter.Init(config)
+}
+
+func (w *SyslogTraceWriter) dialSocket() (io.WriteCloser, error) {
+	if w.Journal {
+		return net.Dial("unixgram", journalSocket)
+	}
+	var err error
+	for _, path := range syslogSockets {
+		var conn net.Conn
+		if conn, err = net.Dial("unixgram", path); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("Unable to connect to syslog: %v", err)
+}
+
+// logRecord is a message to log with its details as name, value pairs.
+type logRecord struct {
+	severity int
+	msgID    string
+	text     string
+	fields   []reportField
+}
+
+func (w *SyslogTraceWriter) records(r *Result) []logRecord {
+	target, port := r.Target, strconv.Itoa(r.Port)
+	var records []logRecord
+
+	for _, h := range reportHops(r) {
+		hosts := strings.Join(h.Hosts, ", ")
+		if hosts == "" {
+			hosts = "*"
+		}
+		texts := make([]string, len(h.Probes))
+		for i, p := range h.Probes {
+			texts[i] = p.Text
+		}
+		rec := logRecord{
+			severity: syslogInfo,
+			msgID:    "hop",
+			text:     fmt.Sprintf("hop %d: %v  %v", h.TTL, hosts, strings.Join(texts, ", ")),
+			fields: []reportField{
+				{"target", target},
+				{"port", port},
+				{"hop", strconv.Itoa(h.TTL)},
+			},
+		}
+		if h.Hop.Received > 0 {
+			var responders []string
+			for _, addr := range h.Hop.Responders {
+				responders = append(responders, addr.String())
+			}
+			rec.fields = append(rec.fields,
+				reportField{"responder", strings.Join(responders, ",")},
+				reportField{"rtt", strconv.FormatFloat(millis(h.Hop.RTTAvg), 'f', 3, 64)})
+		}
+		rec.fields = append(rec.fields, reportField{"loss", strconv.FormatFloat(h.Hop.Loss, 'f', 3, 64)})
+		records = append(records, rec)
+	}
+
+	hops := 0
+	if n := len(r.Hops); n > 0 {
+		hops = r.Hops[n-1].TTL
+	}
+	if r.Destination != nil {
+		hops = r.Destination.Hop
+	}
+	summary := logRecord{
+		severity: syslogNotice,
+		msgID:    "trace",
+		text:     fmt.Sprintf("tracetcp to %v port %v: %v", target, port, traceSummary(r)),
+		fields: []reportField{
+			{"target", target},
+			{"port", port},
+			{"addr", r.Addr.String()},
+			{"status", r.Status.String()},
+			{"reached", strconv.FormatBool(r.Reached)},
+			{"hops", strconv.Itoa(hops)},
+			{"duration", strconv.FormatFloat(millis(r.Duration), 'f', 3, 64)},
+		},
+	}
+	switch {
+	case r.Status == TraceFailed:
+		summary.severity = syslogError
+	case !r.Reached:
+		summary.severity = syslogWarning
+	}
+	return append(records, summary)
+}
+
+func (w *SyslogTraceWriter) writeLog(r *Result) error {
+	conn, err := w.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	now := timeNow()
+	for _, rec := range w.records(r) {
+		msg := w.syslogMessage(rec, now)
+		if w.Journal {
+			msg = journalMessage(rec)
+		}
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var sdValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "]", `\]`)
+
+// syslogField returns s, or "-" if it is empty, as RFC 5424 header fields
+// can not be empty.
+func syslogField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.Replace(s, " ", "_", -1)
+}
+
+func (w *SyslogTraceWriter) syslogMessage(rec logRecord, now time.Time) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "<%d>1 %v %v tracetcp %d %v [%v@%d",
+		syslogFacilityUser*8+rec.severity, now.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogField(w.hostname), w.pid, rec.msgID, rec.msgID, w.Enterprise)
+	for _, f := range rec.fields {
+		fmt.Fprintf(&msg, ` %v="%v"`, f.Name, sdValueEscaper.Replace(f.Value))
+	}
+	fmt.Fprintf(&msg, "] %v", rec.text)
+	return msg.Bytes()
+}
+
+// journalMessage formats rec for journald's native protocol. values holding
+// a newline are sent with their length instead of being newline terminated.
+func journalMessage(rec logRecord) []byte {
+	var msg bytes.Buffer
+	field := func(name, value string) {
+		if !strings.Contains(value, "\n") {
+			fmt.Fprintf(&msg, "%v=%v\n", name, value)
+			return
+		}
+		msg.WriteString(name + "\n")
+		binary.Write(&msg, binary.LittleEndian, uint64(len(value)))
+		msg.WriteString(value + "\n")
+	}
+
+	field("MESSAGE", rec.text)
+	field("PRIORITY", strconv.Itoa(rec.severity))
+	field("SYSLOG_FACILITY", strconv.Itoa(syslogFacilityUser))
+	field("SYSLOG_IDENTIFIER", "tracetcp")
+	field("TRACETCP_RECORD", rec.msgID)
+	for _, f := range rec.fields {
+		field(strings.ToUpper(f.Name), f.Value)
+	}
+	return msg.Bytes()
+}
@@ -0,0 +1,71 @@
+package tracetcp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/0xcafed00d/assert"
+)
+
+// messageLog records each write as a message, as a datagram socket would.
+type messageLog struct {
+	messages []string
+}
+
+func (l *messageLog) Write(p []byte) (int, error) {
+	l.messages = append(l.messages, string(p))
+	return len(p), nil
+}
+
+func (l *messageLog) Close() error {
+	return nil
+}
+
+func writeSyslogTrace(journal bool, events []TraceEvent) []string {
+	log := &messageLog{}
+	w := &SyslogTraceWriter{
+		Journal:  journal,
+		dial:     func() (io.WriteCloser, error) { return log, nil },
+		hostname: "tracer.example.net",
+		pid:      1234,
+	}
+	w.lookup = testLookup
+	writeGraphTrace(w, events)
+	return log.messages
+}
+
+func TestSyslogTraceWriter(t *testing.T) {
+	assert := assert.Make(t)
+	defer fixedClock()()
+
+	msgs := writeSyslogTrace(false, testTraceEvents())
+	assert(len(msgs)).Equal(4)
+	assert(msgs[0]).Equal(`<14>1 2020-01-02T03:04:07.000000Z tracer.example.net tracetcp 1234 hop [hop@32473 target="test.example.com" port="80" hop="1" responder="10.0.0.1,10.0.0.2" rtt="2.000" loss="0.333"] hop 1: router1.example.net (10.0.0.1), 10.0.0.2  1.0 ms, 3.0 ms, *`)
+	assert(msgs[1]).Equal(`<14>1 2020-01-02T03:04:07.000000Z tracer.example.net tracetcp 1234 hop [hop@32473 target="test.example.com" port="80" hop="2" loss="1.000"] hop 2: *  *`)
+	assert(msgs[3]).Equal(`<13>1 2020-01-02T03:04:07.000000Z tracer.example.net tracetcp 1234 trace [trace@32473 target="test.example.com" port="80" addr="10.0.0.9" status="Connected" reached="true" hops="3" duration="2000.000"] tracetcp to test.example.com port 80: Connected to port 80 at hop 3`)
+
+	msgs = writeSyslogTrace(false, unreachableTraceEvents())
+	assert(strings.HasPrefix(msgs[len(msgs)-1], "<12>1 ")).IsTrue()
+
+	w, err := GetOutputWriter("syslog=99999")
+	assert(err).NoError()
+	assert(w.(*SyslogTraceWriter).Enterprise).Equal(99999)
+	assert(GetOutputWriter("syslog=example")).HasError()
+	assert(GetOutputWriter("journald=99999")).HasError()
+}
+
+func TestJournalTraceWriter(t *testing.T) {
+	assert := assert.Make(t)
+	defer fixedClock()()
+
+	msgs := writeSyslogTrace(true, testTraceEvents())
+	assert(len(msgs)).Equal(4)
+	assert(msgs[2]).Equal("MESSAGE=hop 3: test.example.com (10.0.0.9)  5.0 ms\n" +
+		"PRIORITY=6\nSYSLOG_FACILITY=1\nSYSLOG_IDENTIFIER=tracetcp\nTRACETCP_RECORD=hop\n" +
+		"TARGET=test.example.com\nPORT=80\nHOP=3\nRESPONDER=10.0.0.9\nRTT=5.000\nLOSS=0.000\n")
+
+	msg := journalMessage(logRecord{text: "two\nlines"})
+	assert(bytes.HasPrefix(msg, []byte("MESSAGE\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\nPRIORITY=0\n"))).IsTrue()
+}
@@ -0,0 +1,119 @@
+package tracetcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// tcpEvent is a SYN-ACK or RST received from a remote host, seen on a raw
+// socket so that the IP and TCP headers can be inspected.
+type tcpEvent struct {
+	timeStamp time.Time
+
+	localAddr  net.IPAddr
+	localPort  int
+	remoteAddr net.IPAddr
+	remotePort int
+	err        error
+
+	ip      IPHeader
+	tcp     TCPHeader
+	options TCPOptions
+
+	// options area as received, including any padding, for fingerprinting
+	rawOptions []byte
+	badOptions bool
+}
+
+// implementation of fmt.Stinger interface
+func (e tcpEvent) String() string {
+	return fmt.Sprintf("tcpEvent:{time: %v, local: %v:%d, remote: %v:%d, flags: %#02x, options: %v, err: %v}",
+		e.timeStamp, e.localAddr, e.localPort, e.remoteAddr, e.remotePort, e.tcp.Flags, e.options, e.err)
+}
+
+func (e tcpEvent) isSynAck() bool {
+	return e.tcp.Flags&(tcpFlagSYN|tcpFlagACK) == tcpFlagSYN|tcpFlagACK
+}
+
+func (e tcpEvent) isReset() bool {
+	return e.tcp.Flags&tcpFlagRST != 0
+}
+
+// listenTCP opens the raw socket used to see SYN-ACK and RST replies.
+func listenTCP() (int, error) {
+	sock, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_TCP)
+	if err != nil {
+		return -1, fmt.Errorf("%v. Did you forget to run as root?", err)
+	}
+	err = setReceiveTimeout(sock)
+	if err != nil {
+		syscall.Close(sock)
+		return -1, err
+	}
+	return sock, nil
+}
+
+// receiveTCP reads from sock until done is closed, then closes it.
+func receiveTCP(sock int, result chan tcpEvent, done chan struct{}) {
+	defer syscall.Close(sock)
+
+	var buf = make([]byte, 1500)
+	for {
+		n, _, err := syscall.Recvfrom(sock, buf, 0)
+		if isClosed(done) {
+			return
+		}
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			select {
+			case result <- tcpEvent{err: err, timeStamp: time.Now()}:
+			case <-done:
+			}
+			return
+		}
+		event := tcpEvent{timeStamp: time.Now()}
+		pkt := buf[:n]
+
+		err = binary.Read(bytes.NewReader(pkt), binary.BigEndian, &event.ip)
+		if err != nil || event.ip.Protocol != syscall.IPPROTO_TCP {
+			continue
+		}
+
+		ipheaderlen := int(event.ip.VerHdrLen&0xf) * 4
+		if ipheaderlen < ipHeaderLen || len(pkt) < ipheaderlen {
+			continue
+		}
+
+		var complete bool
+		event.tcp, event.options, complete, err = decodeTCPHeader(pkt[ipheaderlen:])
+		if err != nil {
+			continue
+		}
+		event.badOptions = !complete
+
+		if hdrlen := int(event.tcp.DataOffset>>4) * 4; hdrlen > tcpHeaderLen && ipheaderlen+hdrlen <= len(pkt) {
+			event.rawOptions = append([]byte{}, pkt[ipheaderlen+tcpHeaderLen:ipheaderlen+hdrlen]...)
+		}
+
+		// only handshake replies are of interest
+		if !event.isSynAck() && !event.isReset() {
+			continue
+		}
+
+		event.localAddr.IP = append(event.localAddr.IP, event.ip.DestIP[:]...)
+		event.localPort = int(event.tcp.DestPort)
+		event.remoteAddr.IP = append(event.remoteAddr.IP, event.ip.SourceIP[:]...)
+		event.remotePort = int(event.tcp.SrcPort)
+		select {
+		case result <- event:
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package tracetcp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+type TCPOptionKind byte
+
+const (
+	TCPOptEnd           TCPOptionKind = 0
+	TCPOptNOP           TCPOptionKind = 1
+	TCPOptMSS           TCPOptionKind = 2
+	TCPOptWindowScale   TCPOptionKind = 3
+	TCPOptSACKPermitted TCPOptionKind = 4
+	TCPOptSACK          TCPOptionKind = 5
+	TCPOptTimestamps    TCPOptionKind = 8
+	TCPOptMPTCP         TCPOptionKind = 30
+	TCPOptFastOpen      TCPOptionKind = 34
+)
+
+// maximum space available for options in a TCP header
+const maxTCPOptionsLen = 40
+
+// implementation of fmt.Stinger interface
+func (k TCPOptionKind) String() string {
+	switch k {
+	case TCPOptEnd:
+		return "eol"
+	case TCPOptNOP:
+		return "nop"
+	case TCPOptMSS:
+		return "mss"
+	case TCPOptWindowScale:
+		return "wscale"
+	case TCPOptSACKPermitted:
+		return "sack"
+	case TCPOptSACK:
+		return "sackblocks"
+	case TCPOptTimestamps:
+		return "ts"
+	case TCPOptMPTCP:
+		return "mptcp"
+	case TCPOptFastOpen:
+		return "tfo"
+	}
+	return fmt.Sprintf("opt%d", byte(k))
+}
+
+type TCPOption struct {
+	Kind TCPOptionKind
+	Data []byte
+}
+
+// implementation of fmt.Stinger interface
+func (o TCPOption) String() string {
+	name := o.Kind.String()
+	switch o.Kind {
+	case TCPOptMSS:
+		if len(o.Data) == 2 {
+			return fmt.Sprintf("mss=%d", binary.BigEndian.Uint16(o.Data))
+		}
+		// a malformed option is named by number, as the value of mss or
+		// wscale is read back as decimal
+		name = fmt.Sprintf("opt%d", byte(o.Kind))
+	case TCPOptWindowScale:
+		if len(o.Data) == 1 {
+			return fmt.Sprintf("wscale=%d", o.Data[0])
+		}
+		name = fmt.Sprintf("opt%d", byte(o.Kind))
+	case TCPOptTimestamps, TCPOptMPTCP, TCPOptSACKPermitted, TCPOptNOP, TCPOptEnd:
+		return name
+	}
+	if len(o.Data) > 0 {
+		return fmt.Sprintf("%v=%s", name, hex.EncodeToString(o.Data))
+	}
+	return name
+}
+
+func (o TCPOption) Equal(other TCPOption) bool {
+	return o.Kind == other.Kind && string(o.Data) == string(other.Data)
+}
+
+// TCPOptions is an ordered list of options as carried in a TCP header.
+type TCPOptions []TCPOption
+
+// the option set and order sent by a default linux stack
+var DefaultTCPOptions = TCPOptions{
+	{Kind: TCPOptMSS, Data: []byte{0x05, 0xb4}},
+	{Kind: TCPOptSACKPermitted},
+	{Kind: TCPOptTimestamps, Data: make([]byte, 8)},
+	{Kind: TCPOptNOP},
+	{Kind: TCPOptWindowScale, Data: []byte{7}},
+}
+
+// implementation of fmt.Stinger interface
+func (opts TCPOptions) String() string {
+	s := make([]string, len(opts))
+	for i, o := range opts {
+		s[i] = o.String()
+	}
+	return strings.Join(s, ",")
+}
+
+func (opts TCPOptions) Find(kind TCPOptionKind) (TCPOption, bool) {
+	for _, o := range opts {
+		if o.Kind == kind {
+			return o, true
+		}
+	}
+	return TCPOption{}, false
+}
+
+// With returns a copy of opts with o replacing any option of the same kind,
+// or appended if there is none.
+func (opts TCPOptions) With(o TCPOption) TCPOptions {
+	result := append(TCPOptions{}, opts...)
+	for i := range result {
+		if result[i].Kind == o.Kind {
+			result[i] = o
+			return result
+		}
+	}
+	return append(result, o)
+}
+
+// MSS returns the value of the MSS option, if present.
+func (opts TCPOptions) MSS() (int, bool) {
+	if o, ok := opts.Find(TCPOptMSS); ok && len(o.Data) == 2 {
+		return int(binary.BigEndian.Uint16(o.Data)), true
+	}
+	return 0, false
+}
+
+// Marshal encodes the options in wire format, padded with EOL to a
+// multiple of 4 bytes.
+func (opts TCPOptions) Marshal() ([]byte, error) {
+	var b []byte
+	for _, o := range opts {
+		if o.Kind == TCPOptEnd || o.Kind == TCPOptNOP {
+			b = append(b, byte(o.Kind))
+			continue
+		}
+		b = append(b, byte(o.Kind), byte(len(o.Data)+2))
+		b = append(b, o.Data...)
+	}
+	for len(b)%4 != 0 {
+		b = append(b, byte(TCPOptEnd))
+	}
+	if len(b) > maxTCPOptionsLen {
+		return nil, fmt.Errorf("TCP options too long: %d bytes (max %d)", len(b), maxTCPOptionsLen)
+	}
+	return b, nil
+}
+
+// DecodeTCPOptions decodes the options area of a TCP header. Padding after
+// an EOL option is discarded.
+func DecodeTCPOptions(b []byte) (TCPOptions, error) {
+	opts := TCPOptions{}
+	for len(b) > 0 {
+		kind := TCPOptionKind(b[0])
+		if kind == TCPOptEnd {
+			break
+		}
+		if kind == TCPOptNOP {
+			opts = append(opts, TCPOption{Kind: kind})
+			b = b[1:]
+			continue
+		}
+		if len(b) < 2 || int(b[1]) < 2 || int(b[1]) > len(b) {
+			return opts, fmt.Errorf("malformed TCP option %v", kind)
+		}
+		optlen := int(b[1])
+		opt := TCPOption{Kind: kind}
+		if optlen > 2 {
+			opt.Data = append(opt.Data, b[2:optlen]...)
+		}
+		opts = append(opts, opt)
+		b = b[optlen:]
+	}
+	return opts, nil
+}
+
+// ParseTCPOptionSpec parses a comma separated option list such as
+// "mss=1460,sack,ts,nop,wscale=7,tfo,mptcp". Options are sent in the order
+// given.
+func ParseTCPOptionSpec(spec string) (TCPOptions, error) {
+	opts := TCPOptions{}
+	if strings.TrimSpace(spec) == "" {
+		return opts, nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		name, value := strings.TrimSpace(item), ""
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value = name[:i], name[i+1:]
+		}
+
+		switch name {
+		case "nop":
+			opts = append(opts, TCPOption{Kind: TCPOptNOP})
+		case "mss":
+			mss, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid mss value: %v", value)
+			}
+			data := make([]byte, 2)
+			binary.BigEndian.PutUint16(data, uint16(mss))
+			opts = append(opts, TCPOption{Kind: TCPOptMSS, Data: data})
+		case "wscale":
+			ws, err := strconv.ParseUint(value, 10, 8)
+			if err != nil || ws > 14 {
+				return nil, fmt.Errorf("Invalid wscale value: %v", value)
+			}
+			opts = append(opts, TCPOption{Kind: TCPOptWindowScale, Data: []byte{byte(ws)}})
+		case "sack":
+			opts = append(opts, TCPOption{Kind: TCPOptSACKPermitted})
+		case "ts":
+			opts = append(opts, TCPOption{Kind: TCPOptTimestamps, Data: make([]byte, 8)})
+		case "tfo":
+			var cookie []byte
+			if value != "" {
+				var err error
+				cookie, err = hex.DecodeString(value)
+				if err != nil || len(cookie) < 4 || len(cookie) > 16 {
+					return nil, fmt.Errorf("Invalid tfo cookie: %v", value)
+				}
+			}
+			opts = append(opts, TCPOption{Kind: TCPOptFastOpen, Data: cookie})
+		case "mptcp":
+			// MP_CAPABLE, version 1, HMAC-SHA256
+			opts = append(opts, TCPOption{Kind: TCPOptMPTCP, Data: []byte{0x01, 0x01}})
+		default:
+			return nil, fmt.Errorf("Unknown TCP option: %v", name)
+		}
+	}
+
+	if _, err := opts.Marshal(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// ParseTCPOptions reads back options written by TCPOptions.String. each
+// option is a kind, by name or as optN, followed by its data in hex, or its
+// value for mss and wscale. options written by name alone, such as ts, are
+// read without data, as their data was not written.
+func ParseTCPOptions(s string) (TCPOptions, error) {
+	opts := TCPOptions{}
+	if s == "" {
+		return opts, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		name, value := item, ""
+		if i := strings.Index(item, "="); i >= 0 {
+			name, value = item[:i], item[i+1:]
+		}
+
+		kind, ok := tcpOptionKind(name)
+		if !ok {
+			return nil, fmt.Errorf("Unknown TCP option: %v", name)
+		}
+		opt := TCPOption{Kind: kind}
+		switch {
+		case name == "mss":
+			mss, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid mss value: %v", value)
+			}
+			opt.Data = make([]byte, 2)
+			binary.BigEndian.PutUint16(opt.Data, uint16(mss))
+		case name == "wscale":
+			ws, err := strconv.ParseUint(value, 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid wscale value: %v", value)
+			}
+			opt.Data = []byte{byte(ws)}
+		case value != "":
+			data, err := hex.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %v option data: %v", name, value)
+			}
+			opt.Data = data
+		}
+		opts = append(opts, opt)
+	}
+	return opts, nil
+}
+
+// tcpOptionKind returns the kind named by TCPOptionKind.String, or given by
+// number as optN.
+func tcpOptionKind(name string) (TCPOptionKind, bool) {
+	if strings.HasPrefix(name, "opt") {
+		if n, err := strconv.ParseUint(name[3:], 10, 8); err == nil {
+			return TCPOptionKind(n), true
+		}
+	}
+	for k := 0; k < 256; k++ {
+		if TCPOptionKind(k).String() == name {
+			return TCPOptionKind(k), true
+		}
+	}
+	return 0, false
+}
+
+type TCPOptionChangeType int
+
+const (
+	OptionStripped TCPOptionChangeType = iota
+	OptionModified
+	OptionAdded
+)
+
+// implementation of fmt.Stinger interface
+func (t TCPOptionChangeType) String() string {
+	switch t {
+	case OptionStripped:
+		return "stripped"
+	case OptionModified:
+		return "modified"
+	case OptionAdded:
+		return "added"
+	}
+	return "Invalid TCPOptionChangeType"
+}
+
+type TCPOptionChange struct {
+	Type TCPOptionChangeType
+	Sent TCPOption
+	Seen TCPOption
+}
+
+// implementation of fmt.Stinger interface
+func (c TCPOptionChange) String() string {
+	switch c.Type {
+	case OptionStripped:
+		return fmt.Sprintf("%v stripped", c.Sent.Kind)
+	case OptionModified:
+		return fmt.Sprintf("%v -> %v", c.Sent, c.Seen)
+	case OptionAdded:
+		return fmt.Sprintf("%v added", c.Seen)
+	}
+	return "Invalid TCPOptionChange"
+}
+
+// CompareTCPOptions reports the differences between the options that were
+// sent and the options seen further along the path. Padding options are
+// ignored.
+func CompareTCPOptions(sent, seen TCPOptions) []TCPOptionChange {
+	var changes []TCPOptionChange
+
+	for _, s := range sent {
+		if s.Kind == TCPOptNOP || s.Kind == TCPOptEnd {
+			continue
+		}
+		if o, ok := seen.Find(s.Kind); !ok {
+			changes = append(changes, TCPOptionChange{Type: OptionStripped, Sent: s})
+		} else if !o.Equal(s) {
+			changes = append(changes, TCPOptionChange{Type: OptionModified, Sent: s, Seen: o})
+		}
+	}
+
+	for _, o := range seen {
+		if o.Kind == TCPOptNOP || o.Kind == TCPOptEnd {
+			continue
+		}
+		if _, ok := sent.Find(o.Kind); !ok {
+			changes = append(changes, TCPOptionChange{Type: OptionAdded, Seen: o})
+		}
+	}
+	return changes
+}
+
+// HopOptionChange locates where along the path an option change happened.
+// the change was made by a device between PrevHop, the previous hop to quote
+// the options (0 if none did), and Hop. Sent is the option as PrevHop quoted
+// it, or as it was sent.
+type HopOptionChange struct {
+	TCPOptionChange
+	Hop       int
+	PrevHop   int
+	Responder net.IPAddr
+}
+
+// implementation of fmt.Stinger interface
+func (c HopOptionChange) String() string {
+	if c.PrevHop == 0 {
+		return fmt.Sprintf("%v: first seen at hop %d (%v)", c.TCPOptionChange, c.Hop, c.Responder.String())
+	}
+	return fmt.Sprintf("%v: between hop %d and hop %d (%v)", c.TCPOptionChange, c.PrevHop, c.Hop, c.Responder.String())
+}
+
+// FindOptionChanges reports the first hop at which each change to the options
+// was observed. each hop's quote is compared with the quote of the previous
+// hop to reply, or with the options sent if none did, so an option changed
+// more than once, such as an mss clamped by two tunnels in turn, is located
+// each time.
+func FindOptionChanges(events []TraceEvent) []HopOptionChange {
+	var found []HopOptionChange
+	var prev, last TCPOptions
+	prevHop, lastHop := 0, 0
+
+	for _, e := range events {
+		if e.Type != TTLExpired || e.SentOptions == nil || !e.ReplyOptionsSeen {
+			continue
+		}
+		if e.Hop != lastHop {
+			if lastHop != 0 {
+				prev, prevHop = last, lastHop
+			}
+			lastHop = e.Hop
+		}
+		if prevHop == 0 {
+			prev = e.SentOptions
+		}
+		last = e.ReplyOptions
+
+		for _, c := range CompareTCPOptions(prev, e.ReplyOptions) {
+			seen := false
+			for _, f := range found {
+				if f.Type == c.Type && f.Sent.Equal(c.Sent) && f.Seen.Equal(c.Seen) {
+					seen = true
+					break
+				}
+			}
+			if !seen {
+				found = append(found, HopOptionChange{
+					TCPOptionChange: c,
+					Hop:             e.Hop,
+					PrevHop:         prevHop,
+					Responder:       e.Addr,
+				})
+			}
+		}
+	}
+	return found
+}
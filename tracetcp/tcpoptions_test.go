@@ -0,0 +1,118 @@
+package tracetcp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/0xcafed00d/assert"
+)
+
+func TestTCPOptionsRoundTrip(t *testing.T) {
+	assert := assert.Make(t)
+
+	opts, err := ParseTCPOptionSpec("mss=1460,sack,ts,nop,wscale=7")
+	assert(err).NoError()
+	assert(opts.String()).Equal("mss=1460,sack,ts,nop,wscale=7")
+
+	b, err := opts.Marshal()
+	assert(err).NoError()
+	assert(len(b)).Equal(20)
+	assert(DecodeTCPOptions(b)).NoError().Equal(opts, nil)
+
+	assert(ParseTCPOptionSpec("mss=abc")).HasError()
+	assert(ParseTCPOptionSpec("wscale=15")).HasError()
+	assert(ParseTCPOptionSpec("bogus")).HasError()
+	assert(ParseTCPOptionSpec("tfo=00")).HasError()
+	assert(ParseTCPOptionSpec("mss=1,mss=1,mss=1,mss=1,mss=1,mss=1,mss=1,mss=1,mss=1,mss=1,mss=1")).HasError()
+
+	_, err = DecodeTCPOptions([]byte{2, 4, 5})
+	assert(err).HasError()
+}
+
+func TestParseTCPOptions(t *testing.T) {
+	assert := assert.Make(t)
+
+	opts := TCPOptions{
+		{Kind: TCPOptMSS, Data: []byte{0x05, 0xb4}},
+		{Kind: TCPOptSACKPermitted},
+		{Kind: TCPOptNOP},
+		{Kind: TCPOptWindowScale, Data: []byte{7}},
+		{Kind: TCPOptFastOpen, Data: []byte{1, 2, 3, 4}},
+		{Kind: TCPOptFastOpen},
+		{Kind: TCPOptMSS, Data: []byte{5}},
+		{Kind: TCPOptWindowScale},
+		{Kind: 99, Data: []byte{0xab}},
+	}
+	s := opts.String()
+	assert(s).Equal("mss=1460,sack,nop,wscale=7,tfo=01020304,tfo,opt2=05,opt3,opt99=ab")
+	read, err := ParseTCPOptions(s)
+	assert(err).NoError()
+	assert(read.String()).Equal(s)
+	for i := range opts {
+		assert(read[i].Equal(opts[i])).IsTrue()
+	}
+
+	// the timestamp value is not written, so is not read back
+	read, err = ParseTCPOptions("ts,mptcp")
+	assert(err).NoError()
+	assert(read[0].Kind, len(read[0].Data), read[1].Kind).Equal(TCPOptTimestamps, 0, TCPOptMPTCP)
+
+	assert(ParseTCPOptions("")).NoError().Equal(TCPOptions{}, nil)
+	assert(ParseTCPOptions("mss=1460,bogus")).HasError()
+	assert(ParseTCPOptions("mss")).HasError()
+	assert(ParseTCPOptions("wscale=300")).HasError()
+	assert(ParseTCPOptions("tfo=xyz")).HasError()
+	assert(ParseTCPOptions("opt256")).HasError()
+}
+
+func TestCompareTCPOptions(t *testing.T) {
+	assert := assert.Make(t)
+
+	sent, _ := ParseTCPOptionSpec("mss=1460,sack,ts,nop,wscale=7")
+	seen, _ := ParseTCPOptionSpec("mss=1360,nop,nop,ts,nop,wscale=7,mptcp")
+
+	changes := CompareTCPOptions(sent, seen)
+	assert(len(changes)).Equal(3)
+	assert(changes[0].String()).Equal("mss=1460 -> mss=1360")
+	assert(changes[1].String()).Equal("sack stripped")
+	assert(changes[2].String()).Equal("mptcp added")
+
+	assert(len(CompareTCPOptions(sent, sent))).Equal(0)
+}
+
+func TestFindOptionChanges(t *testing.T) {
+	assert := assert.Make(t)
+
+	sent, _ := ParseTCPOptionSpec("mss=1460,sack")
+	clamped, _ := ParseTCPOptionSpec("mss=1360,sack")
+	router := net.IPAddr{IP: net.IPv4(10, 0, 0, 3)}
+
+	events := []TraceEvent{
+		{Type: TTLExpired, Hop: 1, SentOptions: sent, ReplyOptions: sent, ReplyOptionsSeen: true},
+		{Type: TimedOut, Hop: 2, SentOptions: sent},
+		{Type: TTLExpired, Hop: 3, Addr: router, SentOptions: sent, ReplyOptions: clamped, ReplyOptionsSeen: true},
+		{Type: TTLExpired, Hop: 4, SentOptions: sent, ReplyOptions: clamped, ReplyOptionsSeen: true},
+	}
+
+	changes := FindOptionChanges(events)
+	assert(len(changes)).Equal(1)
+	assert(changes[0].Hop, changes[0].PrevHop).Equal(3, 1)
+	assert(changes[0].String()).Equal("mss=1460 -> mss=1360: between hop 1 and hop 3 (10.0.0.3)")
+
+	// two tunnels, each clamping the mss further
+	tunnel1, _ := ParseTCPOptionSpec("mss=1400,sack")
+	tunnel2, _ := ParseTCPOptionSpec("mss=1360,sack")
+	events = []TraceEvent{
+		{Type: TTLExpired, Hop: 2, SentOptions: sent, ReplyOptions: sent, ReplyOptionsSeen: true},
+		{Type: TTLExpired, Hop: 3, Addr: router, SentOptions: sent, ReplyOptions: tunnel1, ReplyOptionsSeen: true},
+		{Type: TTLExpired, Hop: 3, Addr: router, SentOptions: sent, ReplyOptions: tunnel1, ReplyOptionsSeen: true},
+		{Type: TTLExpired, Hop: 4, SentOptions: sent, ReplyOptions: tunnel1, ReplyOptionsSeen: true},
+		{Type: TTLExpired, Hop: 5, Addr: net.IPAddr{IP: net.IPv4(10, 0, 0, 5)}, SentOptions: sent, ReplyOptions: tunnel2, ReplyOptionsSeen: true},
+		{Type: TTLExpired, Hop: 6, SentOptions: sent, ReplyOptions: tunnel2, ReplyOptionsSeen: true},
+	}
+
+	changes = FindOptionChanges(events)
+	assert(len(changes)).Equal(2)
+	assert(changes[0].String()).Equal("mss=1460 -> mss=1400: between hop 2 and hop 3 (10.0.0.3)")
+	assert(changes[1].String()).Equal("mss=1400 -> mss=1360: between hop 4 and hop 5 (10.0.0.5)")
+}
@@ -0,0 +1,100 @@
+package tracetcp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateTraceWriter renders the Result of a trace through a text/template
+// given as -o template=FILE or inline as -o 'template:{{...}}'.
+type TemplateTraceWriter struct {
+	resultWriter
+	tmpl *template.Template
+}
+
+func (w *TemplateTraceWriter) Usage() string {
+	return "template=FILE|template:TEXT"
+}
+
+// Configure takes the name of a template file after =, or the template itself
+// after :, in which \n and \t are newline and tab.
+func (w *TemplateTraceWriter) Configure(sep byte, arg string) error {
+	var text string
+	switch {
+	case arg == "":
+		return fmt.Errorf("a template file or text is required")
+	case sep == ':':
+		text = strings.NewReplacer(`\n`, "\n", `\t`, "\t").Replace(arg)
+	default:
+		data, err := ioutil.ReadFile(arg)
+		if err != nil {
+			return err
+		}
+		text = string(data)
+	}
+
+	tmpl, err := template.New("output").Funcs(w.funcs()).Parse(text)
+	if err != nil {
+		return err
+	}
+	w.tmpl = tmpl
+	return nil
+}
+
+func (w *TemplateTraceWriter) Init(config OutputConfig) error {
+	if w.tmpl == nil {
+		return fmt.Errorf("template output needs a template")
+	}
+	w.render = w.writeTemplate
+	return w.resultWriter.Init(config)
+}
+
+func (w *TemplateTraceWriter) writeTemplate(r *Result) error {
+	return w.tmpl.Execute(w.config.Out, r)
+}
+
+// funcs are the helpers available to templates, in addition to the text/template
+// builtins.
+func (w *TemplateTraceWriter) funcs() template.FuncMap {
+	return template.FuncMap{
+		// duration formats d in milliseconds, e.g. "12.3ms"
+		"duration": func(d time.Duration) string {
+			return fmt.Sprintf("%.1fms", millis(d))
+		},
+		"ms": millis,
+		"percent": func(f float64) string {
+			return fmt.Sprintf("%.0f%%", f*100)
+		},
+		"padLeft": func(width int, v interface{}) string {
+			return fmt.Sprintf("%*v", width, v)
+		},
+		"padRight": func(width int, v interface{}) string {
+			return fmt.Sprintf("%-*v", width, v)
+		},
+		// join the elements of any slice, e.g. a hop's Responders
+		"join": func(sep string, list interface{}) string {
+			v := reflect.ValueOf(list)
+			if v.Kind() != reflect.Slice {
+				return fmt.Sprint(list)
+			}
+			items := make([]string, v.Len())
+			for i := range items {
+				items[i] = fmt.Sprint(v.Index(i).Interface())
+			}
+			return strings.Join(items, sep)
+		},
+		// lookup returns the reverse DNS name of ip, empty with -n
+		"lookup": func(ip net.IP) string {
+			if ip == nil || w.config.NoLookups {
+				return ""
+			}
+			name, _ := w.lookup(net.IPAddr{IP: ip})
+			return name
+		},
+	}
+}
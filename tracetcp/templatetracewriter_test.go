@@ -0,0 +1,52 @@
+package tracetcp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/0xcafed00d/assert"
+)
+
+func writeTemplateTrace(spec string) (string, error) {
+	w, err := GetOutputWriter(spec)
+	if err != nil {
+		return "", err
+	}
+	w.(*TemplateTraceWriter).lookup = testLookup
+	var out bytes.Buffer
+	if err := w.Init(OutputConfig{Options: testOptions(), Out: &out}); err != nil {
+		return "", err
+	}
+	for _, e := range testTraceEvents() {
+		if err := w.Event(e); err != nil {
+			return "", err
+		}
+	}
+	return out.String(), w.Close()
+}
+
+func TestTemplateTraceWriter(t *testing.T) {
+	assert := assert.Make(t)
+
+	assert(writeTemplateTrace(`template:{{.Target}} {{.Status}}\n{{range .Hops}}{{.TTL}}={{join "," .Responders}};{{end}}`)).
+		NoError().Equal("test.example.com Connected\n1=10.0.0.1,10.0.0.2;2=;3=10.0.0.9;", nil)
+
+	assert(writeTemplateTrace(`template:{{lookup .Addr}} {{padLeft 6 (ms .Destination.RTT)}}`)).
+		NoError().Equal("test.example.com      5", nil)
+
+	assert(writeTemplateTrace("template=testdata/hops.tmpl")).NoError().Equal(
+		"*test.example.com* port 80: Connected at hop 3\n"+
+			" 1 10.0.0.1, 10.0.0.2                       2.0ms loss 33%\n"+
+			"   0: router1.example.net\n"+
+			" 2 *                                        - loss 100%\n"+
+			" 3 10.0.0.9                                 5.0ms loss 0%\n"+
+			"   0: test.example.com\n", nil)
+
+	// the separator chooses between inline text and a file, whatever the text
+	assert(writeTemplateTrace(`template:no actions\n`)).NoError().Equal("no actions\n", nil)
+	_, err := writeTemplateTrace(`template=testdata/{{.Target}}.tmpl`)
+	assert(err).HasError()
+
+	_, err = writeTemplateTrace(`template:{{.Hops.Bogus}}`)
+	assert(err).HasError()
+}
@@ -0,0 +1,199 @@
+package tracetcp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+type topologyNodeKind string
+
+const (
+	topologyLocal       topologyNodeKind = "local"
+	topologyHop         topologyNodeKind = "hop"
+	topologyAnonymous   topologyNodeKind = "anonymous"
+	topologyDestination topologyNodeKind = "destination"
+)
+
+// topologyNode is a host on the traced path. an address seen at several TTLs
+// is a single node.
+type topologyNode struct {
+	ID   string
+	Kind topologyNodeKind
+	TTL  int
+	Addr net.IP
+	Name string
+	ASN  string
+
+	// average RTT of the probes this host answered at TTL
+	RTT time.Duration
+
+	// false for a placeholder or a destination that never answered
+	Reached bool
+}
+
+func (n *topologyNode) labelLines() []string {
+	var lines []string
+	switch n.Kind {
+	case topologyLocal:
+		lines = append(lines, "local")
+	case topologyAnonymous:
+		return []string{"*"}
+	}
+	if n.Name != "" {
+		lines = append(lines, n.Name)
+	}
+	if n.Addr != nil {
+		lines = append(lines, n.Addr.String())
+	}
+	if n.ASN != "" {
+		lines = append(lines, n.ASN)
+	}
+	if n.RTT > 0 {
+		lines = append(lines, fmt.Sprintf("%.1fms", float64(n.RTT)/float64(time.Millisecond)))
+	}
+	return lines
+}
+
+type topologyEdge struct {
+	From, To string
+
+	// no probe was seen to pass both ends, as when the probe answered at To
+	// timed out at From's TTL, or To was not reached
+	Inferred bool
+}
+
+type topology struct {
+	Nodes []*topologyNode
+	Edges []topologyEdge
+}
+
+// topologyTTL is the nodes at one TTL and the query each answered.
+type topologyTTL struct {
+	nodes    []*topologyNode
+	answered []*topologyNode
+	queries  []int
+}
+
+// at returns the node a probe of query passed through, or nil if it is not
+// known. a TTL with a single node is taken to be passed by every probe.
+func (h topologyTTL) at(query int) *topologyNode {
+	if len(h.nodes) == 1 {
+		return h.nodes[0]
+	}
+	for i, q := range h.queries {
+		if q == query {
+			return h.answered[i]
+		}
+	}
+	return nil
+}
+
+// buildTopology turns a result into a graph running from the local host to
+// the destination. a responder is joined to the one that answered the same
+// query at the previous TTL, so load balanced paths show as parallel
+// branches. a responder with no such query is joined to every responder at
+// the previous TTL by inferred edges, as is a placeholder node for a TTL with
+// no replies. asn may be nil.
+func buildTopology(r *Result, asn func(ip net.IPAddr) (string, error)) topology {
+	var t topology
+	byID := map[string]*topologyNode{}
+	edges := map[[2]string]int{}
+
+	addNode := func(n *topologyNode) *topologyNode {
+		if existing, ok := byID[n.ID]; ok {
+			return existing
+		}
+		if asn != nil && n.Addr != nil {
+			n.ASN, _ = asn(net.IPAddr{IP: n.Addr})
+		}
+		byID[n.ID] = n
+		t.Nodes = append(t.Nodes, n)
+		return n
+	}
+	addEdge := func(from, to *topologyNode, inferred bool) {
+		if from.ID == to.ID {
+			return
+		}
+		key := [2]string{from.ID, to.ID}
+		if i, ok := edges[key]; ok {
+			t.Edges[i].Inferred = t.Edges[i].Inferred && inferred
+			return
+		}
+		edges[key] = len(t.Edges)
+		t.Edges = append(t.Edges, topologyEdge{From: from.ID, To: to.ID, Inferred: inferred})
+	}
+	link := func(prev, cur topologyTTL) {
+		for _, to := range cur.nodes {
+			linked := false
+			for i, n := range cur.answered {
+				if from := prev.at(cur.queries[i]); n == to && from != nil {
+					addEdge(from, to, false)
+					linked = true
+				}
+			}
+			if !linked {
+				for _, from := range prev.nodes {
+					addEdge(from, to, true)
+				}
+			}
+		}
+	}
+
+	prev := topologyTTL{nodes: []*topologyNode{addNode(&topologyNode{ID: "local", Kind: topologyLocal, Addr: r.LocalAddr, Reached: true})}}
+	for _, hop := range r.Hops {
+		var cur topologyTTL
+		for _, addr := range hop.Responders {
+			n := &topologyNode{ID: addr.String(), Kind: topologyHop, TTL: hop.TTL, Addr: addr, Reached: true}
+			if addr.Equal(r.Addr) {
+				n.Kind = topologyDestination
+			}
+			var total time.Duration
+			var queries []int
+			for _, p := range hop.Probes {
+				if p.Replied() && p.Addr.Equal(addr) {
+					total += p.RTT
+					queries = append(queries, p.Query)
+					if n.Name == "" {
+						n.Name = p.Name
+					}
+				}
+			}
+			if len(queries) > 0 {
+				n.RTT = total / time.Duration(len(queries))
+			}
+			n = addNode(n)
+			cur.nodes = append(cur.nodes, n)
+			for _, q := range queries {
+				cur.answered = append(cur.answered, n)
+				cur.queries = append(cur.queries, q)
+			}
+		}
+		if len(cur.nodes) == 0 {
+			cur.nodes = append(cur.nodes, addNode(&topologyNode{ID: fmt.Sprintf("hop%d", hop.TTL), Kind: topologyAnonymous, TTL: hop.TTL}))
+		}
+		link(prev, cur)
+		prev = cur
+	}
+
+	if !r.Reached && r.Addr != nil {
+		dest := addNode(&topologyNode{ID: r.Addr.String(), Kind: topologyDestination, Addr: r.Addr, Name: r.AddrName})
+		link(prev, topologyTTL{nodes: []*topologyNode{dest}})
+	}
+	return t
+}
+
+func (t topology) node(id string) *topologyNode {
+	for _, n := range t.Nodes {
+		if n.ID == id {
+			return n
+		}
+	}
+	return nil
+}
+
+// dotQuote quotes s as a DOT ID.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
@@ -0,0 +1,111 @@
+package tracetcp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/0xcafed00d/assert"
+)
+
+func testASN(ip net.IPAddr) (string, error) {
+	if ip.String() == "10.0.0.9" {
+		return "AS64500", nil
+	}
+	return "", nil
+}
+
+func testResult(events []TraceEvent) *Result {
+	r := NewResult(testOptions())
+	for _, e := range events {
+		if e.Type == TraceStarted {
+			e.Source = net.IPAddr{IP: net.IPv4(10, 0, 0, 100).To4()}
+		}
+		r.Add(e)
+	}
+	r.LookupNames(testLookup)
+	return r
+}
+
+func TestBuildTopology(t *testing.T) {
+	assert := assert.Make(t)
+
+	topo := buildTopology(testResult(testTraceEvents()), testASN)
+	var ids []string
+	for _, n := range topo.Nodes {
+		ids = append(ids, n.ID)
+	}
+	assert(ids).Equal([]string{"local", "10.0.0.1", "10.0.0.2", "hop2", "10.0.0.9"})
+	assert(topo.Edges).Equal([]topologyEdge{
+		{"local", "10.0.0.1", false}, {"local", "10.0.0.2", false},
+		{"10.0.0.1", "hop2", true}, {"10.0.0.2", "hop2", true},
+		{"hop2", "10.0.0.9", false},
+	})
+	dest := topo.node("10.0.0.9")
+	assert(dest.Kind, dest.ASN, dest.Reached).Equal(topologyDestination, "AS64500", true)
+	assert(dest.labelLines()).Equal([]string{"test.example.com", "10.0.0.9", "AS64500", "5.0ms"})
+
+	// not reached, the destination hangs off the last hop
+	topo = buildTopology(testResult(testTraceEvents()[:5]), nil)
+	dest = topo.node("10.0.0.9")
+	assert(dest.Reached).IsFalse()
+	assert(topo.Edges[len(topo.Edges)-1]).Equal(topologyEdge{"hop2", "10.0.0.9", true})
+
+	// two load balanced paths, joined by the query each probe was sent with
+	r := func(n byte) net.IPAddr { return net.IPAddr{IP: net.IPv4(10, 0, 0, n).To4()} }
+	topo = buildTopology(testResult([]TraceEvent{
+		{Type: TraceStarted, Addr: r(9)},
+		{Type: TTLExpired, Hop: 1, Query: 0, Addr: r(1)},
+		{Type: TTLExpired, Hop: 1, Query: 1, Addr: r(2)},
+		{Type: TimedOut, Hop: 1, Query: 2},
+		{Type: TTLExpired, Hop: 2, Query: 0, Addr: r(3)},
+		{Type: TTLExpired, Hop: 2, Query: 1, Addr: r(4)},
+		{Type: TTLExpired, Hop: 2, Query: 2, Addr: r(5)},
+		{Type: TraceComplete},
+	}), nil)
+	assert(topo.Edges).Equal([]topologyEdge{
+		{"local", "10.0.0.1", false}, {"local", "10.0.0.2", false},
+		{"10.0.0.1", "10.0.0.3", false}, {"10.0.0.2", "10.0.0.4", false},
+		{"10.0.0.1", "10.0.0.5", true}, {"10.0.0.2", "10.0.0.5", true},
+		{"10.0.0.3", "10.0.0.9", true}, {"10.0.0.4", "10.0.0.9", true}, {"10.0.0.5", "10.0.0.9", true},
+	})
+}
+
+func TestLookupASNSkipsPrivate(t *testing.T) {
+	assert := assert.Make(t)
+
+	for _, ip := range []string{"10.1.2.3", "192.168.1.1", "172.16.0.1", "127.0.0.1", "169.254.1.1", "100.64.0.1", "192.0.2.1", "224.0.0.1"} {
+		assert(isPublicAddr(net.ParseIP(ip))).IsFalse()
+		assert(LookupASN(net.IPAddr{IP: net.ParseIP(ip)})).HasError()
+	}
+	assert(isPublicAddr(net.ParseIP("1.1.1.1"))).IsTrue()
+}
+
+func writeGraphTrace(w TraceOutputWriter, events []TraceEvent) []byte {
+	var out bytes.Buffer
+	w.Init(OutputConfig{Options: testOptions(), Out: &out})
+	for _, e := range events {
+		if e.Type == TraceStarted {
+			e.Source = net.IPAddr{IP: net.IPv4(10, 0, 0, 100).To4()}
+		}
+		w.Event(e)
+	}
+	w.Close()
+	return out.Bytes()
+}
+
+func TestGraphWritersGolden(t *testing.T) {
+	defer fixedClock()()
+
+	dot := &DOTTraceWriter{asn: testASN}
+	dot.lookup = testLookup
+	checkGolden(t, "connected.dot", writeGraphTrace(dot, testTraceEvents()))
+
+	dot = &DOTTraceWriter{asn: testASN}
+	dot.lookup = testLookup
+	checkGolden(t, "incomplete.dot", writeGraphTrace(dot, testTraceEvents()[:5]))
+
+	graphml := &GraphMLTraceWriter{asn: testASN}
+	graphml.lookup = testLookup
+	checkGolden(t, "connected.graphml", writeGraphTrace(graphml, testTraceEvents()))
+}
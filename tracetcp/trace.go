@@ -20,6 +20,7 @@ const (
 	TraceComplete
 	TraceAborted
 	TraceFailed
+	Unreachable
 )
 
 // implementation of fmt.Stinger interface
@@ -43,10 +44,28 @@ func (t TraceEventType) String() string {
 		return "TraceAborted"
 	case TraceFailed:
 		return "TraceFailed"
+	case Unreachable:
+		return "Unreachable"
 	}
 	return "Invalid TraceEventType"
 }
 
+// implementation of encoding.TextMarshaler interface
+func (t TraceEventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// implementation of encoding.TextUnmarshaler interface
+func (t *TraceEventType) UnmarshalText(text []byte) error {
+	for v := None; v <= Unreachable; v++ {
+		if v.String() == string(text) {
+			*t = v
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid TraceEventType: %s", text)
+}
+
 type TraceEvent struct {
 	Type  TraceEventType
 	Addr  net.IPAddr
@@ -54,18 +73,101 @@ type TraceEvent struct {
 	Hop   int
 	Query int
 	Err   error
+
+	// when the probe was sent, or on TraceStarted when the trace started
+	Sent time.Time
+
+	// SentOptions is only known for SYN probes. ReplyOptions are the options
+	// quoted back in a Time Exceeded reply, or carried by the SYN-ACK at the
+	// destination.
+	SentOptions      TCPOptions
+	ReplyOptions     TCPOptions
+	ReplyOptionsSeen bool
+
+	// the outcome of the fast open test, on the Connected event
+	FastOpen FastOpenResult
+
+	// remaining TTL of the reply, the TTL it was most likely sent with and
+	// so the number of hops it took to get back to us
+	ReplyTTL    int
+	InitialTTL  int
+	ReverseHops int
+
+	// type and code of an ICMP reply: 11/0 for TTLExpired, 3/code for
+	// Unreachable
+	ICMPType int
+	ICMPCode int
+
+	// what sent the SYN-ACK or RST that ended the trace, and what it looks like
+	Responder   ResponderInfo
+	Fingerprint StackFingerprint
+
+	// local address the probes are sent from, on the TraceStarted event
+	Source net.IPAddr
 }
 
 // implementation of fmt.Stinger interface
 func (e TraceEvent) String() string {
-	return fmt.Sprintf("TraceEvent:{type: %v, addr: %v, timetaken: %v, hop: %d, query %d, err: %v}",
-		e.Type, e.Addr, e.Time, e.Hop, e.Query, e.Err)
+	return fmt.Sprintf("TraceEvent:{type: %v, addr: %v, timetaken: %v, hop: %d, query %d, replyttl: %d, err: %v}",
+		e.Type, e.Addr, e.Time, e.Hop, e.Query, e.ReplyTTL, e.Err)
+}
+
+// OptionChanges compares the options sent with those quoted back by the
+// router at this hop.
+func (e TraceEvent) OptionChanges() []TCPOptionChange {
+	if e.Type != TTLExpired || e.SentOptions == nil || !e.ReplyOptionsSeen {
+		return nil
+	}
+	return CompareTCPOptions(e.SentOptions, e.ReplyOptions)
+}
+
+type ProbeType int
+
+const (
+	ProbeConnect ProbeType = iota
+	ProbeSYN
+)
+
+// implementation of fmt.Stinger interface
+func (p ProbeType) String() string {
+	switch p {
+	case ProbeConnect:
+		return "connect"
+	case ProbeSYN:
+		return "syn"
+	}
+	return "Invalid ProbeType"
+}
+
+func ParseProbeType(name string) (ProbeType, error) {
+	switch name {
+	case "connect":
+		return ProbeConnect, nil
+	case "syn":
+		return ProbeSYN, nil
+	}
+	return ProbeConnect, fmt.Errorf("Invalid probe type: %v", name)
+}
+
+// implementation of encoding.TextMarshaler interface
+func (p ProbeType) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// implementation of encoding.TextUnmarshaler interface
+func (p *ProbeType) UnmarshalText(text []byte) (err error) {
+	*p, err = ParseProbeType(string(text))
+	return err
 }
 
 type Trace struct {
 	Events         chan TraceEvent
 	TraceRunning   AtomicBool
 	AbortRequested AtomicBool
+
+	// if set, replies are received by Listener instead of by sockets opened
+	// for the trace
+	Listener *Listener
 }
 
 func NewTrace() *Trace {
@@ -77,11 +179,35 @@ func NewTrace() *Trace {
 }
 
 func (t *Trace) BeginTrace(addr *net.IPAddr, port, beginTTL, endTTL, queries int, timeout time.Duration) error {
+	opts := DefaultOptions
+	opts.Addr = addr
+	opts.Port = port
+	opts.StartHop = beginTTL
+	opts.EndHop = endTTL
+	opts.Queries = queries
+	opts.Timeout = timeout
+	return t.Start(opts)
+}
+
+// Start validates opts, resolves the target and begins the trace. progress
+// is reported on the Events channel, which ends with a TraceComplete event.
+func (t *Trace) Start(opts Options) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if err := opts.resolve(); err != nil {
+		return err
+	}
+	if opts.Addr.IP.To4() == nil {
+		return fmt.Errorf("Only IPv4 targets are supported: %v", opts.Addr)
+	}
+
 	if !t.TraceRunning.CompareAndSet(false, true) {
 		return fmt.Errorf("Trace already in progress")
 	}
+	t.AbortRequested.Write(false)
 
-	go t.traceImpl(addr, port, beginTTL, endTTL, queries, timeout)
+	go t.traceImpl(opts)
 	return nil
 }
 
@@ -89,34 +215,77 @@ func (t *Trace) AbortTrace() {
 	t.AbortRequested.Write(true)
 }
 
-func (t *Trace) traceImpl(addr *net.IPAddr, port, beginTTL, endTTL, queries int, timeout time.Duration) {
-
-	icmpChan := make(chan icmpEvent, 100)
-	go receiveICMP(icmpChan)
+func (t *Trace) traceImpl(opts Options) {
 
+	addr, port := opts.Addr, opts.Port
 	traceStart := time.Now()
-	t.Events <- TraceEvent{Addr: *addr, Type: TraceStarted, Time: time.Since(traceStart)}
-	for ttl := beginTTL; ttl <= endTTL; ttl++ {
-		for q := 0; q < queries; q++ {
+	source := opts.source()
+	if source.IP == nil {
+		source, _ = localAddrFor(*addr)
+	}
+	t.Events <- TraceEvent{Addr: *addr, Source: source, Type: TraceStarted, Time: time.Since(traceStart), Sent: traceStart}
+
+	listener := t.Listener
+	if listener == nil {
+		var err error
+		if listener, err = NewListener(); err != nil {
+			t.failTrace(err, traceStart)
+			return
+		}
+		defer listener.Close()
+	}
+	sub := listener.subscribe(addr.IP)
+	defer listener.unsubscribe(sub)
+	icmpChan, tcpChan := sub.icmp, sub.tcp
+
+	tcpOpts := opts.probeOptions()
+	var hopEvents []TraceEvent
+
+	for ttl := opts.StartHop; ttl <= opts.EndHop; ttl++ {
+		for q := 0; q < opts.Queries; q++ {
 			if t.AbortRequested.Read() {
-				// TODO: abort trace
+				t.Events <- TraceEvent{Type: TraceAborted, Hop: ttl, Query: q, Time: time.Since(traceStart)}
+				t.completeTrace(traceStart)
+				return
 			}
 			log.Printf("Probe query: %v hops: %v", q, ttl)
 			queryStart := time.Now()
-			ev := tryConnect(*addr, port, ttl, q, timeout)
-			if t.correlateEvents(ev, icmpChan, queryStart) {
-				t.Events <- TraceEvent{Type: TraceComplete, Time: time.Since(traceStart)}
+			var ev connectEvent
+			var icmpev icmpEvent
+			var tcpev tcpEvent
+			if opts.ProbeType == ProbeSYN {
+				ev, icmpev, tcpev = trySYN(*addr, port, ttl, q, opts.Timeout, opts.source(), tcpOpts, nil, icmpChan, tcpChan)
+			} else {
+				ev = tryConnect(*addr, port, ttl, q, opts.Timeout, opts.source())
+				icmpev, tcpev = collectEvents(ev, icmpChan, tcpChan)
+			}
+			traceEvent, finished := correlateEvents(ev, icmpev, tcpev, queryStart)
+			traceEvent.Responder = ClassifyResponder(hopEvents, traceEvent)
+			if traceEvent.Fingerprint.Signature != "" {
+				traceEvent.Fingerprint.Label, _ = opts.fingerprintDB().Match(traceEvent.Fingerprint)
+			}
+			if finished && traceEvent.Type == Connected && opts.FastOpen {
+				traceEvent.FastOpen = tryFastOpen(*addr, port, ttl, opts.Timeout, opts.source(), tcpOpts, icmpChan, tcpChan)
+				traceEvent.FastOpen.locateStripping(hopEvents)
+			}
+			t.Events <- traceEvent
+			if finished {
+				t.completeTrace(traceStart)
 				return
 			}
+			hopEvents = append(hopEvents, traceEvent)
 		}
 	}
-	t.Events <- TraceEvent{Type: TraceComplete, Time: time.Since(traceStart)}
-	t.TraceRunning.Write(false)
+	t.completeTrace(traceStart)
 }
 
-func (t *Trace) correlateEvents(ev connectEvent, icmpChan chan icmpEvent, queryStart time.Time) bool {
+func (t *Trace) completeTrace(traceStart time.Time) {
+	t.TraceRunning.Write(false)
+	t.Events <- TraceEvent{Type: TraceComplete, Time: time.Since(traceStart)}
+}
 
-	icmpev := icmpEvent{}
+// collectEvents gathers the icmp and tcp replies that match a connect probe.
+func collectEvents(ev connectEvent, icmpChan chan icmpEvent, tcpChan chan tcpEvent) (icmpev icmpEvent, tcpev tcpEvent) {
 
 	// collect all pending icmp events
 	done := false
@@ -133,66 +302,110 @@ func (t *Trace) correlateEvents(ev connectEvent, icmpChan chan icmpEvent, queryS
 				icmpev = iev
 			}
 
+		case tev := <-tcpChan:
+			if tev.err != nil || tev.localPort == ev.localPort && tev.remotePort == ev.remotePort && tev.remoteAddr.IP.Equal(ev.remoteAddr.IP) {
+				tcpev = tev
+			}
+
 		case <-time.After(100 * time.Millisecond):
 			done = true
 		}
 	}
+	return
+}
+
+func (t *Trace) failTrace(err error, traceStart time.Time) {
+	t.Events <- TraceEvent{Type: TraceFailed, Err: err, Time: time.Since(traceStart)}
+	t.completeTrace(traceStart)
+}
+
+// correlateEvents builds the trace event for a probe from its replies. done is
+// true once the destination has been reached or the trace has failed.
+func correlateEvents(ev connectEvent, icmpev icmpEvent, tcpev tcpEvent, queryStart time.Time) (traceEvent TraceEvent, done bool) {
+
 	log.Println(ev)
 	if icmpev.evtype == icmpNone {
 		log.Println("No matching ICMP event")
 	} else {
 		log.Println("matching icmp event", icmpev)
 	}
+	if !tcpev.timeStamp.IsZero() {
+		log.Println("matching tcp event", tcpev)
+	}
+
+	traceEvent = TraceEvent{
+		Hop:         ev.ttl,
+		Query:       ev.query,
+		Time:        ev.timeStamp.Sub(queryStart),
+		Sent:        queryStart,
+		SentOptions: ev.sentOptions,
+	}
 
-	traceEvent := TraceEvent{
-		Hop:   ev.ttl,
-		Query: ev.query,
-		Time:  time.Since(queryStart),
+	// the kernel reports some unreachables as connect errors, so check first
+	if icmpev.evtype == icmpNoRoute && ev.evtype != connectConnected {
+		traceEvent.Type = Unreachable
+		traceEvent.Addr = icmpev.remoteAddr
+		traceEvent.Time = icmpev.timeStamp.Sub(queryStart)
+		traceEvent.ICMPType, traceEvent.ICMPCode = icmpev.icmpType, icmpev.icmpCode
+		traceEvent.setReplyTTL(icmpev.ttl)
+		return traceEvent, true
 	}
 
 	if ev.evtype == connectError {
 		traceEvent.Type = TraceFailed
 		traceEvent.Err = ev.err
-		t.Events <- traceEvent
-		return true
+		return traceEvent, true
 	}
 
 	if icmpev.evtype == icmpError {
 		traceEvent.Type = TraceFailed
 		traceEvent.Err = icmpev.err
-		t.Events <- traceEvent
-		return true
+		return traceEvent, true
+	}
+
+	if tcpev.err != nil {
+		traceEvent.Type = TraceFailed
+		traceEvent.Err = tcpev.err
+		return traceEvent, true
 	}
 
 	if icmpev.evtype == icmpTTLExpired && ev.evtype == connectUnreachable {
 		traceEvent.Type = TTLExpired
 		traceEvent.Addr = icmpev.remoteAddr
 		traceEvent.Time = icmpev.timeStamp.Sub(queryStart)
-		t.Events <- traceEvent
-		return false
+		traceEvent.ReplyOptions = icmpev.quotedOptions
+		traceEvent.ReplyOptionsSeen = icmpev.optionsQuoted
+		traceEvent.ICMPType, traceEvent.ICMPCode = icmpev.icmpType, icmpev.icmpCode
+		traceEvent.setReplyTTL(icmpev.ttl)
+		return traceEvent, false
+	}
+
+	if !tcpev.timeStamp.IsZero() {
+		traceEvent.Time = tcpev.timeStamp.Sub(queryStart)
+		traceEvent.setReplyTTL(int(tcpev.ip.TTL))
+		traceEvent.Fingerprint = fingerprintReply(tcpev)
+		if tcpev.isSynAck() {
+			traceEvent.ReplyOptions = tcpev.options
+			traceEvent.ReplyOptionsSeen = true
+		}
 	}
 
 	if ev.evtype == connectConnected {
 		traceEvent.Type = Connected
 		traceEvent.Addr = ev.remoteAddr
-		t.Events <- traceEvent
-		return true
+		return traceEvent, true
 	}
 
 	if ev.evtype == connectTimedOut || ev.evtype == connectUnreachable {
 		traceEvent.Type = TimedOut
-		t.Events <- traceEvent
-		return false
+		return traceEvent, false
 	}
 
 	if ev.evtype == connectRefused {
 		traceEvent.Type = RemoteClosed
 		traceEvent.Addr = ev.remoteAddr
-		t.Events <- traceEvent
-		return true
+		return traceEvent, true
 	}
 
 	panic("should not get here???")
-
-	return false
 }
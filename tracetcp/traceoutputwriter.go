@@ -3,21 +3,147 @@ package tracetcp
 import (
 	"fmt"
 	"io"
+	"sort"
+	"strings"
+	"sync"
 )
 
+// OutputConfig describes the trace being written. Options are those the trace
+// was started with; the resolved address arrives with the TraceStarted event.
+type OutputConfig struct {
+	Options
+	NoLookups bool
+
+	// look up the origin AS of each public address, which the dot and
+	// graphml formats label nodes with. independent of NoLookups
+	ASNLookups bool
+
+	Out io.Writer
+}
+
 type TraceOutputWriter interface {
-	Init(port int, hopsFrom, hopsTo, queriesPerHop int, noLookups bool, out io.Writer)
+	Init(config OutputConfig) error
 	Event(e TraceEvent) error
+
+	// Close is called once the trace has completed, or been abandoned, to
+	// flush anything still buffered.
+	Close() error
+}
+
+// ConfigurableOutputWriter is implemented by writers that take an argument,
+// given after the format name as name=ARG or name:ARG. Configure is passed the
+// separator used, '=' or ':', or 0 if there was no argument.
+type ConfigurableOutputWriter interface {
+	TraceOutputWriter
+	Configure(sep byte, arg string) error
+
+	// how the format and its argument are written in help text
+	Usage() string
 }
 
-var outputWriters = map[string]TraceOutputWriter{
-	"std":  &StdTraceWriter{},
-	"json": &JSONTraceWriter{},
+// OutputWriterFactory returns a new writer, so that each trace has its own.
+type OutputWriterFactory func() TraceOutputWriter
+
+var (
+	outputWritersLock sync.RWMutex
+	outputWriters     = map[string]OutputWriterFactory{}
+)
+
+func init() {
+	RegisterOutputWriter("std", func() TraceOutputWriter { return &StdTraceWriter{} })
+	RegisterOutputWriter("json", func() TraceOutputWriter { return &JSONTraceWriter{} })
+	RegisterOutputWriter("ndjson", func() TraceOutputWriter { return &NDJSONTraceWriter{} })
+	RegisterOutputWriter("csv", func() TraceOutputWriter { return &CSVTraceWriter{Comma: ','} })
+	RegisterOutputWriter("tsv", func() TraceOutputWriter { return &CSVTraceWriter{Comma: '\t'} })
+	RegisterOutputWriter("dot", func() TraceOutputWriter { return &DOTTraceWriter{} })
+	RegisterOutputWriter("graphml", func() TraceOutputWriter { return &GraphMLTraceWriter{} })
+	RegisterOutputWriter("svg", func() TraceOutputWriter { return &SVGTraceWriter{} })
+	RegisterOutputWriter("template", func() TraceOutputWriter { return &TemplateTraceWriter{} })
+	RegisterOutputWriter("traceroute", func() TraceOutputWriter { return &TracerouteTraceWriter{} })
+	RegisterOutputWriter("mtr", func() TraceOutputWriter { return &MTRTraceWriter{} })
+	RegisterOutputWriter("mtr-json", func() TraceOutputWriter { return &MTRTraceWriter{JSON: true} })
+	RegisterOutputWriter("markdown", func() TraceOutputWriter { return &MarkdownTraceWriter{} })
+	RegisterOutputWriter("html", func() TraceOutputWriter { return &HTMLTraceWriter{} })
+	RegisterOutputWriter("prometheus", func() TraceOutputWriter { return &PrometheusTraceWriter{} })
+	RegisterOutputWriter("influx", func() TraceOutputWriter { return &InfluxTraceWriter{} })
+	RegisterOutputWriter("syslog", func() TraceOutputWriter { return &SyslogTraceWriter{} })
+	RegisterOutputWriter("journald", func() TraceOutputWriter { return &SyslogTraceWriter{Journal: true} })
+	RegisterOutputWriter("atlas", func() TraceOutputWriter { return &AtlasTraceWriter{} })
+	RegisterOutputWriter("scamper", func() TraceOutputWriter { return &ScamperTraceWriter{} })
+}
+
+// RegisterOutputWriter makes an output format available by name. it panics if
+// the name is already in use.
+func RegisterOutputWriter(name string, factory OutputWriterFactory) {
+	outputWritersLock.Lock()
+	defer outputWritersLock.Unlock()
+
+	if factory == nil {
+		panic("tracetcp: RegisterOutputWriter factory is nil")
+	}
+	if _, dup := outputWriters[name]; dup {
+		panic("tracetcp: RegisterOutputWriter called twice for " + name)
+	}
+	outputWriters[name] = factory
 }
 
-func GetOutputWriter(name string) (TraceOutputWriter, error) {
-	if writer, ok := outputWriters[name]; ok {
+// OutputWriterNames returns the registered output formats in sorted order.
+func OutputWriterNames() []string {
+	outputWritersLock.RLock()
+	defer outputWritersLock.RUnlock()
+
+	var names []string
+	for name := range outputWriters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OutputWriterHelp lists the output formats for use in help text.
+func OutputWriterHelp() string {
+	names := OutputWriterNames()
+
+	outputWritersLock.RLock()
+	defer outputWritersLock.RUnlock()
+
+	var formats []string
+	for _, name := range names {
+		if c, ok := outputWriters[name]().(ConfigurableOutputWriter); ok {
+			formats = append(formats, c.Usage())
+		} else {
+			formats = append(formats, name)
+		}
+	}
+	return "[" + strings.Join(formats, "|") + "]"
+}
+
+// GetOutputWriter returns a new writer for the named output format. an
+// argument for the writer may follow the name as name=ARG or name:ARG.
+func GetOutputWriter(spec string) (TraceOutputWriter, error) {
+	name, arg, sep := spec, "", byte(0)
+	if i := strings.IndexAny(spec, "=:"); i >= 0 {
+		name, arg, sep = spec[:i], spec[i+1:], spec[i]
+	}
+
+	outputWritersLock.RLock()
+	factory, ok := outputWriters[name]
+	outputWritersLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Invalid output format name: %v", name)
+	}
+
+	writer := factory()
+	c, configurable := writer.(ConfigurableOutputWriter)
+	if !configurable {
+		if sep != 0 {
+			return nil, fmt.Errorf("Output format %v does not take an argument", name)
+		}
 		return writer, nil
 	}
-	return nil, fmt.Errorf("Invalid output format name: %v", name)
+	if err := c.Configure(sep, arg); err != nil {
+		return nil, fmt.Errorf("%v output: %v", name, err)
+	}
+	return writer, nil
 }
@@ -0,0 +1,74 @@
+package tracetcp
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/0xcafed00d/assert"
+)
+
+type nullTraceWriter struct {
+	events int
+}
+
+func (w *nullTraceWriter) Init(config OutputConfig) error { return nil }
+func (w *nullTraceWriter) Event(e TraceEvent) error       { w.events++; return nil }
+func (w *nullTraceWriter) Close() error                   { return nil }
+
+func TestOutputWriterRegistry(t *testing.T) {
+	assert := assert.Make(t)
+
+	RegisterOutputWriter("test-null", func() TraceOutputWriter { return &nullTraceWriter{} })
+	defer func() {
+		outputWritersLock.Lock()
+		delete(outputWriters, "test-null")
+		outputWritersLock.Unlock()
+	}()
+
+	w1, err := GetOutputWriter("test-null")
+	assert(err).NoError()
+	w2, err := GetOutputWriter("test-null")
+	assert(err).NoError()
+	w1.Event(TraceEvent{})
+	assert(w1.(*nullTraceWriter).events, w2.(*nullTraceWriter).events).Equal(1, 0)
+
+	s1, _ := GetOutputWriter("std")
+	s2, _ := GetOutputWriter("std")
+	assert(s1 != s2).IsTrue()
+
+	assert(GetOutputWriter("bogus")).HasError()
+	assert(GetOutputWriter("std=x")).HasError()
+	assert(GetOutputWriter("template")).HasError()
+	assert(GetOutputWriter("template=testdata/missing.tmpl")).HasError()
+	assert(GetOutputWriter("template:{{.Bogus")).HasError()
+	assert(GetOutputWriter("prometheus:metrics.prom")).HasError()
+	names := map[string]bool{}
+	for _, name := range OutputWriterNames() {
+		names[name] = true
+	}
+	assert(names["std"], names["json"], names["test-null"]).Equal(true, true, true)
+	assert(sort.StringsAreSorted(OutputWriterNames())).IsTrue()
+	help := OutputWriterHelp()
+	assert(strings.HasPrefix(help, "["), strings.HasSuffix(help, "]")).Equal(true, true)
+	assert(strings.Contains(help, "|test-null|") || strings.HasSuffix(help, "|test-null]")).IsTrue()
+
+	defer func() {
+		assert(recover() != nil).IsTrue()
+	}()
+	RegisterOutputWriter("std", func() TraceOutputWriter { return &StdTraceWriter{} })
+}
+
+func TestStdTraceWriterInit(t *testing.T) {
+	assert := assert.Make(t)
+
+	var out bytes.Buffer
+	config := OutputConfig{Options: DefaultOptions, NoLookups: true, Out: &out}
+	w, _ := GetOutputWriter("std")
+	assert(w.Init(config)).NoError()
+
+	w.Event(TraceEvent{Type: TraceStarted, Addr: testTraceEvents()[0].Addr})
+	assert(out.String()).Equal("Tracing route to 10.0.0.9 on port 80 over a maximum of 30 hops:\n")
+	assert(w.Close()).NoError()
+}
@@ -0,0 +1,33 @@
+package tracetcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+)
+
+// ReadTrace reads a trace saved by the json or ndjson output writers and
+// returns the options it was run with and its events, ready to be written
+// again by any output writer.
+func ReadTrace(r io.Reader) (Options, []TraceEvent, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Options{}, nil, err
+	}
+
+	// ndjson lines all have an event, a Result does not
+	var first map[string]json.RawMessage
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&first); err != nil {
+		return Options{}, nil, err
+	}
+	if _, ok := first["event"]; ok {
+		return readNDJSON(bytes.NewReader(data))
+	}
+
+	result, err := ReadResult(bytes.NewReader(data))
+	if err != nil {
+		return Options{}, nil, err
+	}
+	return result.TraceOptions(), result.Events(), nil
+}
@@ -0,0 +1,132 @@
+package tracetcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xcafed00d/assert"
+)
+
+func writeEvents(w TraceOutputWriter, opts Options, events []TraceEvent) string {
+	var out bytes.Buffer
+	w.Init(OutputConfig{Options: opts, NoLookups: true, Out: &out})
+	for _, e := range events {
+		w.Event(e)
+	}
+	w.Close()
+	return out.String()
+}
+
+func TestReadTraceJSON(t *testing.T) {
+	assert := assert.Make(t)
+	defer fixedClock()()
+
+	for _, events := range [][]TraceEvent{testTraceEvents(), unreachableTraceEvents()} {
+		saved := writeEvents(&JSONTraceWriter{}, testOptions(), events)
+
+		opts, read, err := ReadTrace(strings.NewReader(saved))
+		assert(err).NoError()
+		assert(opts.Target, opts.Port, opts.Queries).Equal("test.example.com", 80, 3)
+		assert(writeEvents(&JSONTraceWriter{}, opts, read)).Equal(saved)
+		assert(writeEvents(&StdTraceWriter{}, opts, read)).Equal(writeEvents(&StdTraceWriter{}, testOptions(), events))
+	}
+}
+
+// synTraceEvents is a trace of syn probes whose mss is clamped after hop 1.
+func synTraceEvents() []TraceEvent {
+	r1 := net.IPAddr{IP: net.IPv4(10, 0, 0, 1).To4()}
+	r2 := net.IPAddr{IP: net.IPv4(10, 0, 0, 2).To4()}
+	dest := net.IPAddr{IP: net.IPv4(10, 0, 0, 9).To4()}
+	options := func(spec string) TCPOptions {
+		opts, _ := ParseTCPOptions(spec)
+		return opts
+	}
+	sent := options("mss=1460,sack")
+
+	return []TraceEvent{
+		{Type: TraceStarted, Addr: dest},
+		{Type: TTLExpired, Hop: 1, Addr: r1, Time: time.Millisecond, SentOptions: sent, ReplyOptions: options("mss=1460,sack"), ReplyOptionsSeen: true},
+		{Type: TTLExpired, Hop: 2, Addr: r2, Time: 2 * time.Millisecond, SentOptions: sent, ReplyOptions: options("mss=1360"), ReplyOptionsSeen: true},
+		{Type: Connected, Hop: 3, Addr: dest, Time: 3 * time.Millisecond, SentOptions: sent, ReplyOptions: options("mss=1400,sack,wscale=7"), ReplyOptionsSeen: true},
+		{Type: TraceComplete, Time: time.Second},
+	}
+}
+
+func TestReadTraceOptions(t *testing.T) {
+	assert := assert.Make(t)
+	defer fixedClock()()
+
+	opts := testOptions()
+	opts.ProbeType = ProbeSYN
+	std := writeEvents(&StdTraceWriter{}, opts, synTraceEvents())
+	assert(strings.Contains(std, "SYN-ACK options: mss=1400,sack,wscale=7")).IsTrue()
+	assert(strings.Contains(std, "mss=1460 -> mss=1360: between hop 1 and hop 2 (10.0.0.2)")).IsTrue()
+
+	for _, w := range []TraceOutputWriter{&JSONTraceWriter{}, &NDJSONTraceWriter{}} {
+		saved := writeEvents(w, opts, synTraceEvents())
+		opts, read, err := ReadTrace(strings.NewReader(saved))
+		assert(err).NoError()
+		assert(writeEvents(&StdTraceWriter{}, opts, read)).Equal(std)
+	}
+
+	result := NewResult(opts)
+	for _, e := range synTraceEvents() {
+		result.Add(e)
+	}
+	assert(*result.Hops[1].Probes[0].SentOptions, *result.Hops[1].Probes[0].ReplyOptions).Equal("mss=1460,sack", "mss=1360")
+	assert(len(result.Hops[0].OptionChanges), len(result.Hops[1].OptionChanges)).Equal(0, 2)
+	assert(result.Hops[1].OptionChanges[0]).Equal(OptionChange{Type: "modified", Change: "mss=1460 -> mss=1360", PrevHop: 1, Responder: result.Hops[1].Responders[0]})
+	assert(result.Hops[1].OptionChanges[1].Type, result.Hops[1].OptionChanges[1].Change).Equal("stripped", "sack stripped")
+
+	var saved bytes.Buffer
+	json.NewEncoder(&saved).Encode(result)
+	_, err := ReadResult(strings.NewReader(strings.Replace(saved.String(), `"reply_options":"mss=1360"`, `"reply_options":"mss=x"`, 1)))
+	assert(err).HasError()
+}
+
+func TestReadTraceNDJSON(t *testing.T) {
+	assert := assert.Make(t)
+
+	saved := writeEvents(&NDJSONTraceWriter{}, testOptions(), testTraceEvents())
+	opts, read, err := ReadTrace(strings.NewReader(saved))
+	assert(err).NoError()
+	assert(opts.Target, opts.Port, opts.EndHop).Equal("test.example.com", 80, 30)
+	assert(writeEvents(&StdTraceWriter{}, opts, read)).Equal(writeEvents(&StdTraceWriter{}, testOptions(), testTraceEvents()))
+	assert(read[5].Responder.Class, read[5].Fingerprint.Label).Equal(ResponderDestination, "s:unix:Linux:3.x")
+
+	_, _, err = ReadTrace(strings.NewReader(`{"event":"TTLExpired"}`))
+	assert(err).HasError()
+
+	// syn probes sent a second apart, cut short after the second
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	sent, _ := ParseTCPOptionSpec("mss=1460,sack")
+	events := testTraceEvents()[:2]
+	events[0].Sent = start
+	events[1].Sent, events[1].SentOptions = start.Add(time.Second), sent
+	events[1].ReplyOptions, events[1].ReplyOptionsSeen = TCPOptions{}, true
+	events = append(events, TraceEvent{Type: TimedOut, Hop: 2, Time: time.Second, Sent: start.Add(2 * time.Second), SentOptions: sent})
+
+	saved = writeEvents(&NDJSONTraceWriter{}, testOptions(), events)
+	assert(strings.Contains(saved, `"sent_options":"mss=1460,sack","reply_options":""`)).IsTrue()
+	_, read, err = ReadTrace(strings.NewReader(saved))
+	assert(err).NoError()
+	assert(len(read)).Equal(4)
+	assert(read[1].Sent.Equal(start.Add(time.Second)), read[2].Sent.Equal(start.Add(2*time.Second))).Equal(true, true)
+	assert(read[1].SentOptions.String(), read[1].ReplyOptionsSeen, len(read[1].ReplyOptions)).Equal("mss=1460,sack", true, 0)
+	assert(read[2].ReplyOptionsSeen).IsFalse()
+	assert(read[3].Type, read[3].Time).Equal(TraceComplete, 2*time.Second)
+
+	result := NewResult(testOptions())
+	for _, e := range read {
+		result.Add(e)
+	}
+	assert(result.Hops[1].Probes[0].Sent).Equal(2 * time.Second)
+	assert(result.Events()[2].Sent.Equal(start.Add(2 * time.Second))).IsTrue()
+
+	_, _, err = ReadTrace(strings.NewReader(strings.Replace(saved, `"reply_options":""`, `"reply_options":"mss=x"`, 1)))
+	assert(err).HasError()
+}
@@ -0,0 +1,94 @@
+package tracetcp
+
+import (
+	"fmt"
+	"io"
+	"net"
+)
+
+// TracerouteTraceWriter writes in the layout of Linux traceroute -T, one line
+// per hop written as the probes complete:
+//
+//	traceroute to www.example.com (93.184.216.34), 30 hops max, 60 byte packets
+//	 1  router.lan (192.168.1.1)  0.512 ms  0.468 ms  0.455 ms
+//	 2  * * *
+type TracerouteTraceWriter struct {
+	config     OutputConfig
+	out        io.Writer
+	currentHop int
+	lastAddr   net.IP
+	lookup     func(ip net.IPAddr) (string, error)
+}
+
+func (w *TracerouteTraceWriter) Init(config OutputConfig) error {
+	w.config = config
+	w.out = config.Out
+	w.currentHop = 0
+	w.lastAddr = nil
+	if w.lookup == nil {
+		w.lookup = ReverseLookup
+	}
+	return nil
+}
+
+func (w *TracerouteTraceWriter) Close() error {
+	w.endHop()
+	return nil
+}
+
+func (w *TracerouteTraceWriter) endHop() {
+	if w.currentHop != 0 {
+		fmt.Fprintf(w.out, "\n")
+		w.currentHop = 0
+	}
+}
+
+func (w *TracerouteTraceWriter) Event(e TraceEvent) error {
+	switch e.Type {
+	case TraceStarted:
+		target := w.config.Target
+		if target == "" {
+			target = e.Addr.String()
+		}
+		fmt.Fprintf(w.out, "traceroute to %v (%v), %v hops max, %v byte packets\n",
+			target, e.Addr.String(), w.config.EndHop, w.config.probeSize())
+
+	case TimedOut, TTLExpired, Connected, RemoteClosed, Unreachable:
+		if e.Hop != w.currentHop {
+			w.endHop()
+			w.currentHop = e.Hop
+			w.lastAddr = nil
+			fmt.Fprintf(w.out, "%2d ", e.Hop)
+		}
+		if e.Type == TimedOut {
+			fmt.Fprintf(w.out, " *")
+			break
+		}
+		if !e.Addr.IP.Equal(w.lastAddr) {
+			w.lastAddr = e.Addr.IP
+			if w.config.NoLookups {
+				fmt.Fprintf(w.out, " %v", e.Addr.String())
+			} else {
+				name, _ := w.lookup(e.Addr)
+				if name == "" {
+					name = e.Addr.String()
+				}
+				fmt.Fprintf(w.out, " %v (%v)", name, e.Addr.String())
+			}
+		}
+		fmt.Fprintf(w.out, "  %.3f ms", millis(e.Time))
+		if e.Type == Unreachable {
+			if a := UnreachableAnnotation(e.ICMPCode); a != "" {
+				fmt.Fprintf(w.out, " %v", a)
+			}
+		}
+
+	case TraceFailed:
+		w.endHop()
+		fmt.Fprintf(w.out, "%v\n", e.Err)
+
+	case TraceComplete:
+		w.endHop()
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package tracetcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/0xcafed00d/assert"
+)
+
+func unreachableTraceEvents() []TraceEvent {
+	r1 := net.IPAddr{IP: net.IPv4(10, 0, 0, 1).To4()}
+	r2 := net.IPAddr{IP: net.IPv4(10, 0, 0, 2).To4()}
+	r3 := net.IPAddr{IP: net.IPv4(10, 0, 0, 3).To4()}
+	dest := net.IPAddr{IP: net.IPv4(10, 0, 0, 9).To4()}
+
+	return []TraceEvent{
+		{Type: TraceStarted, Addr: dest},
+		{Type: TTLExpired, Hop: 1, Query: 0, Addr: r1, Time: 1 * time.Millisecond, ICMPType: 11},
+		{Type: TTLExpired, Hop: 1, Query: 1, Addr: r1, Time: 2 * time.Millisecond, ICMPType: 11},
+		{Type: TTLExpired, Hop: 1, Query: 2, Addr: r2, Time: 3500 * time.Microsecond, ICMPType: 11},
+		{Type: TimedOut, Hop: 2, Query: 0, Time: time.Second},
+		{Type: TimedOut, Hop: 2, Query: 1, Time: time.Second},
+		{Type: TimedOut, Hop: 2, Query: 2, Time: time.Second},
+		{Type: Unreachable, Hop: 3, Query: 0, Addr: r3, Time: 4 * time.Millisecond, ICMPType: 3, ICMPCode: 13},
+		{Type: TraceComplete, Time: 3 * time.Second},
+	}
+}
+
+func TestTracerouteTraceWriter(t *testing.T) {
+	assert := assert.Make(t)
+
+	write := func(events []TraceEvent, noLookups bool) string {
+		var out bytes.Buffer
+		w := &TracerouteTraceWriter{lookup: testLookup}
+		w.Init(OutputConfig{Options: testOptions(), NoLookups: noLookups, Out: &out})
+		for _, e := range events {
+			w.Event(e)
+		}
+		w.Close()
+		return out.String()
+	}
+
+	assert(write(unreachableTraceEvents(), false)).Equal(
+		"traceroute to test.example.com (10.0.0.9), 30 hops max, 60 byte packets\n" +
+			" 1  router1.example.net (10.0.0.1)  1.000 ms  2.000 ms 10.0.0.2 (10.0.0.2)  3.500 ms\n" +
+			" 2  * * *\n" +
+			" 3  10.0.0.3 (10.0.0.3)  4.000 ms !X\n")
+
+	assert(write(testTraceEvents(), true)).Equal(
+		"traceroute to test.example.com (10.0.0.9), 30 hops max, 60 byte packets\n" +
+			" 1  10.0.0.1  1.000 ms 10.0.0.2  3.000 ms *\n" +
+			" 2  *\n" +
+			" 3  10.0.0.9  5.000 ms\n")
+
+	assert(UnreachableAnnotation(1), UnreachableAnnotation(3), UnreachableAnnotation(99)).Equal("!H", "", "!<99>")
+}
+
+func TestMTRTraceWriterGolden(t *testing.T) {
+	defer fixedClock()()
+
+	for _, json := range []bool{false, true} {
+		w := &MTRTraceWriter{JSON: json, hostname: func() (string, error) { return "tracer.example.net", nil }}
+		w.lookup = testLookup
+		name := "unreachable.mtr"
+		if json {
+			name = "unreachable.mtr.json"
+		}
+		checkGolden(t, name, writeGraphTrace(w, unreachableTraceEvents()))
+	}
+}
+
+func TestMTRHostNames(t *testing.T) {
+	assert := assert.Make(t)
+
+	assert(fit("rüter.example.net", 8), fit("ab", 4)).Equal("rüter.ex", "ab  ")
+
+	w := &MTRTraceWriter{JSON: true, hostname: func() (string, error) { return "host \"1\"\tñ", nil }}
+	w.lookup = testLookup
+	var doc mtrJSON
+	assert(json.Unmarshal(writeGraphTrace(w, unreachableTraceEvents()), &doc)).NoError()
+	assert(doc.Report.MTR.Src, len(doc.Report.Hubs)).Equal("host \"1\"\tñ", 3)
+	assert(doc.Report.Hubs[0].Avg.String()).Equal("2.17")
+}
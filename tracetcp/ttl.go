@@ -0,0 +1,168 @@
+package tracetcp
+
+import (
+	"fmt"
+	"net"
+)
+
+// hops by which forward and reverse path lengths must differ before a hop is
+// reported as asymmetric
+const DefaultAsymmetryThreshold = 3
+
+// InferInitialTTL returns the most likely TTL a packet was sent with, given
+// the TTL it arrived with. stacks almost always start at 64, 128 or 255.
+func InferInitialTTL(ttl int) int {
+	switch {
+	case ttl <= 0:
+		return 0
+	case ttl <= 64:
+		return 64
+	case ttl <= 128:
+		return 128
+	}
+	return 255
+}
+
+func (e *TraceEvent) setReplyTTL(ttl int) {
+	if ttl <= 0 {
+		return
+	}
+	e.ReplyTTL = ttl
+	e.InitialTTL = InferInitialTTL(ttl)
+	// a reply from the first hop arrives with its initial TTL intact, so count
+	// the responder itself to make the figure comparable with Hop
+	e.ReverseHops = e.InitialTTL - ttl + 1
+}
+
+type AsymmetricHop struct {
+	Hop         int
+	ReverseHops int
+	Addr        net.IPAddr
+}
+
+// implementation of fmt.Stinger interface
+func (a AsymmetricHop) String() string {
+	return fmt.Sprintf("hop %d (%v): forward %d hops, reverse %d hops", a.Hop, a.Addr.String(), a.Hop, a.ReverseHops)
+}
+
+// FindAsymmetricHops reports the responders whose replies took a path that
+// differs in length from the forward path by at least threshold hops.
+// each responder is reported once.
+func FindAsymmetricHops(events []TraceEvent, threshold int) []AsymmetricHop {
+	var found []AsymmetricHop
+	reported := map[string]bool{}
+
+	for _, e := range events {
+		if e.ReverseHops == 0 || (e.Type != TTLExpired && e.Type != Connected && e.Type != RemoteClosed) {
+			continue
+		}
+		diff := e.ReverseHops - e.Hop
+		if diff < 0 {
+			diff = -diff
+		}
+		key := fmt.Sprintf("%d/%v", e.Hop, e.Addr.String())
+		if diff >= threshold && !reported[key] {
+			reported[key] = true
+			found = append(found, AsymmetricHop{Hop: e.Hop, ReverseHops: e.ReverseHops, Addr: e.Addr})
+		}
+	}
+	return found
+}
+
+type ResponderClass int
+
+const (
+	ResponderUnknown ResponderClass = iota
+	ResponderDestination
+	ResponderMiddlebox
+	ResponderSYNProxy
+)
+
+// implementation of fmt.Stinger interface
+func (c ResponderClass) String() string {
+	switch c {
+	case ResponderUnknown:
+		return "unknown"
+	case ResponderDestination:
+		return "destination"
+	case ResponderMiddlebox:
+		return "middlebox"
+	case ResponderSYNProxy:
+		return "synproxy"
+	}
+	return "Invalid ResponderClass"
+}
+
+// implementation of encoding.TextMarshaler interface
+func (c ResponderClass) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// implementation of encoding.TextUnmarshaler interface
+func (c *ResponderClass) UnmarshalText(text []byte) error {
+	for v := ResponderUnknown; v <= ResponderSYNProxy; v++ {
+		if v.String() == string(text) {
+			*c = v
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid ResponderClass: %s", text)
+}
+
+// ResponderInfo is a best guess at what sent the SYN-ACK or RST that ended a
+// trace. Hop is the estimated position of a middlebox or SYN proxy.
+type ResponderInfo struct {
+	Class ResponderClass
+	Hop   int
+}
+
+// implementation of fmt.Stinger interface
+func (r ResponderInfo) String() string {
+	switch r.Class {
+	case ResponderDestination:
+		return "likely destination"
+	case ResponderMiddlebox:
+		return fmt.Sprintf("likely middlebox at hop %d", r.Hop)
+	case ResponderSYNProxy:
+		return fmt.Sprintf("SYN proxy at hop %d", r.Hop)
+	}
+	return r.Class.String()
+}
+
+// ClassifyResponder decides whether the SYN-ACK or RST in final came from the
+// destination or from something in front of it. a reply that has come back
+// over no more hops than the last router to answer cannot have come from
+// beyond that router, and a reply whose reverse path is much shorter than the
+// forward hop count was most likely injected part way along the path.
+func ClassifyResponder(hopEvents []TraceEvent, final TraceEvent) ResponderInfo {
+	if final.Type != Connected && final.Type != RemoteClosed || final.ReverseHops == 0 {
+		return ResponderInfo{}
+	}
+
+	middlebox := ResponderMiddlebox
+	if final.Type == Connected {
+		middlebox = ResponderSYNProxy
+	}
+
+	var lastRouter *TraceEvent
+	for i := range hopEvents {
+		e := &hopEvents[i]
+		if e.Type == TTLExpired && e.ReverseHops != 0 && e.Hop < final.Hop &&
+			(lastRouter == nil || e.Hop >= lastRouter.Hop) {
+			lastRouter = e
+		}
+	}
+
+	if lastRouter != nil && final.ReverseHops <= lastRouter.ReverseHops {
+		hop := lastRouter.Hop - (lastRouter.ReverseHops - final.ReverseHops)
+		if hop < 1 {
+			hop = 1
+		}
+		return ResponderInfo{Class: middlebox, Hop: hop}
+	}
+
+	if final.Hop-final.ReverseHops >= DefaultAsymmetryThreshold {
+		return ResponderInfo{Class: middlebox, Hop: final.ReverseHops}
+	}
+	return ResponderInfo{Class: ResponderDestination}
+}
@@ -0,0 +1,72 @@
+package tracetcp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/0xcafed00d/assert"
+)
+
+func TestInferInitialTTL(t *testing.T) {
+	assert := assert.Make(t)
+
+	assert(InferInitialTTL(0)).Equal(0)
+	assert(InferInitialTTL(1)).Equal(64)
+	assert(InferInitialTTL(64)).Equal(64)
+	assert(InferInitialTTL(65)).Equal(128)
+	assert(InferInitialTTL(128)).Equal(128)
+	assert(InferInitialTTL(240)).Equal(255)
+}
+
+func TestFindAsymmetricHops(t *testing.T) {
+	assert := assert.Make(t)
+
+	router := net.IPAddr{IP: net.IPv4(10, 0, 0, 4)}
+	events := []TraceEvent{
+		{Type: TTLExpired, Hop: 1},
+		{Type: TTLExpired, Hop: 2},
+		{Type: TTLExpired, Hop: 4, Addr: router},
+		{Type: TTLExpired, Hop: 4, Addr: router},
+		{Type: Connected, Hop: 5},
+	}
+	events[0].setReplyTTL(255)
+	events[1].setReplyTTL(63)
+	events[2].setReplyTTL(248)
+	events[3].setReplyTTL(248)
+	events[4].setReplyTTL(60)
+
+	assert(events[0].ReverseHops, events[1].ReverseHops).Equal(1, 2)
+
+	found := FindAsymmetricHops(events, DefaultAsymmetryThreshold)
+	assert(len(found)).Equal(1)
+	assert(found[0].String()).Equal("hop 4 (10.0.0.4): forward 4 hops, reverse 8 hops")
+}
+
+func TestClassifyResponder(t *testing.T) {
+	assert := assert.Make(t)
+
+	router := func(hop, ttl int) TraceEvent {
+		e := TraceEvent{Type: TTLExpired, Hop: hop}
+		e.setReplyTTL(ttl)
+		return e
+	}
+	final := func(evtype TraceEventType, hop, ttl int) TraceEvent {
+		e := TraceEvent{Type: evtype, Hop: hop}
+		e.setReplyTTL(ttl)
+		return e
+	}
+
+	hops := []TraceEvent{router(1, 255), router(2, 254), {Type: TimedOut, Hop: 3}, router(4, 252)}
+
+	// the destination is one hop beyond the last router
+	assert(ClassifyResponder(hops, final(RemoteClosed, 5, 60))).Equal(ResponderInfo{Class: ResponderDestination})
+
+	// the RST has travelled no further than the router at hop 4
+	assert(ClassifyResponder(hops, final(RemoteClosed, 5, 252)).String()).Equal("likely middlebox at hop 4")
+
+	// a SYN-ACK from hop 2 answering for a destination at hop 8
+	assert(ClassifyResponder(hops[:2], final(Connected, 8, 63)).String()).Equal("SYN proxy at hop 2")
+
+	assert(ClassifyResponder(hops, final(TimedOut, 5, 0))).Equal(ResponderInfo{})
+	assert(ClassifyResponder(hops, final(Connected, 5, 0)).String()).Equal("unknown")
+}
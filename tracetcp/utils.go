@@ -69,11 +69,11 @@ func MakeTimeval(t time.Duration) syscall.Timeval {
 }
 
 func FD_SET(p *syscall.FdSet, i int) {
-	p.Bits[i/64] |= 1 << uint(i) % 64
+	p.Bits[i/64] |= 1 << (uint(i) % 64)
 }
 
 func FD_ISSET(p *syscall.FdSet, i int) bool {
-	return (p.Bits[i/64] & (1 << uint(i) % 64)) != 0
+	return (p.Bits[i/64] & (1 << (uint(i) % 64))) != 0
 }
 
 func FD_ZERO(p *syscall.FdSet) {
@@ -113,17 +113,98 @@ func ReverseLookup(ip net.IPAddr) (name string, err error) {
 	return
 }
 
+// IPv4 ranges that are not routed on the internet, beyond those recognised
+// by net.IP
+var specialIPv4Nets = parseCIDRs("0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12",
+	"192.0.0.0/24", "192.0.2.0/24", "192.168.0.0/16", "198.18.0.0/15", "198.51.100.0/24",
+	"203.0.113.0/24", "240.0.0.0/4")
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// isPublicAddr is false for private, loopback, link local, multicast,
+// documentation and other addresses that are not routed on the internet.
+func isPublicAddr(ip net.IP) bool {
+	if !ip.IsGlobalUnicast() {
+		return false
+	}
+	for _, n := range specialIPv4Nets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// LookupASN returns the origin AS of ip, e.g. "AS13335", from the Team Cymru
+// IP to ASN DNS service. addresses that are not public are not looked up.
+func LookupASN(ip net.IPAddr) (asn string, err error) {
+	v4 := ip.IP.To4()
+	if v4 == nil {
+		return "", fmt.Errorf("Only IPv4 addresses are supported: %v", ip)
+	}
+	if !isPublicAddr(v4) {
+		return "", fmt.Errorf("No ASN for non-public address %v", ip)
+	}
+	query := fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", v4[3], v4[2], v4[1], v4[0])
+	records, err := net.LookupTXT(query)
+	if err != nil {
+		return "", err
+	}
+	// "13335 | 1.1.1.0/24 | AU | apnic | 2011-08-11", several origins may be
+	// listed separated by spaces
+	for _, r := range records {
+		fields := strings.Fields(strings.SplitN(r, "|", 2)[0])
+		if len(fields) > 0 {
+			return "AS" + fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("No ASN found for %v", ip)
+}
+
+// LookupAddress returns the first address host resolves to.
 func LookupAddress(host string) (*net.IPAddr, error) {
-	addresses, err := net.LookupHost(host)
+	addresses, err := LookupAddresses(host)
 	if err != nil {
 		return &net.IPAddr{}, err
 	}
+	return addresses[0], nil
+}
 
-	ip, err := net.ResolveIPAddr("ip", addresses[0])
+// LookupAddresses returns every IPv4 and IPv6 address host resolves to, in
+// the order the resolver returned them.
+func LookupAddresses(host string) ([]*net.IPAddr, error) {
+	addresses, err := net.LookupHost(host)
 	if err != nil {
-		return &net.IPAddr{}, err
+		return nil, err
+	}
+
+	ips := make([]*net.IPAddr, 0, len(addresses))
+	for _, addr := range addresses {
+		ip, err := net.ResolveIPAddr("ip", addr)
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// AddrFamily returns "ipv4" or "ipv6".
+func AddrFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
 	}
-	return ip, nil
+	return "ipv6"
 }
 
 func ToSockaddrInet4(ip net.IPAddr, port int) *syscall.SockaddrInet4 {
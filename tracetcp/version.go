@@ -0,0 +1,5 @@
+package tracetcp
+
+// Version is reported in the JSON output. release builds set it with
+// -ldflags "-X github.com/0xcafed00d/tracetcp-go/tracetcp.Version=1.2.3"
+var Version = "dev"